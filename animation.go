@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"slices"
+)
+
+var animationFormats = []string{".gif", ".apng"}
+
+// Encodes frames as a looping GIF sharing one median cut palette, so colors
+// don't flicker from frame to frame, optionally with Floyd–Steinberg dithering.
+func encodeGif(w io.Writer, frames []*image.RGBA, fps float64, dither bool) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to encode")
+	}
+
+	palette := medianCutPalette(frames, 256)
+	delay := max(1, int(math.Round(100/fps)))
+	anim := &gif.GIF{}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		if dither {
+			draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, image.Point{})
+		} else {
+			draw.Draw(paletted, frame.Bounds(), frame, image.Point{}, draw.Src)
+		}
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// Builds a palette by repeatedly splitting the box of colors with the widest
+// channel range at its median, sampling at most about a million pixels.
+func medianCutPalette(frames []*image.RGBA, size int) color.Palette {
+	total := 0
+	for _, frame := range frames {
+		total += len(frame.Pix) / 4
+	}
+	stride := max(1, total/(1<<20))
+
+	var colors [][3]uint8
+	i := 0
+	for _, frame := range frames {
+		for p := 0; p < len(frame.Pix); p += 4 {
+			if i%stride == 0 {
+				colors = append(colors, [3]uint8{frame.Pix[p], frame.Pix[p+1], frame.Pix[p+2]})
+			}
+			i++
+		}
+	}
+
+	boxes := []colorBox{newColorBox(colors)}
+	for len(boxes) < size {
+		widest := 0
+		for b, box := range boxes {
+			if box.spread > boxes[widest].spread {
+				widest = b
+			}
+		}
+		box := boxes[widest]
+		if box.spread == 0 {
+			break
+		}
+
+		slices.SortFunc(box.colors, func(a, b [3]uint8) int { return int(a[box.channel]) - int(b[box.channel]) })
+		half := len(box.colors) / 2
+		boxes[widest] = newColorBox(box.colors[:half])
+		boxes = append(boxes, newColorBox(box.colors[half:]))
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var sum [3]int
+		for _, c := range box.colors {
+			sum[0], sum[1], sum[2] = sum[0]+int(c[0]), sum[1]+int(c[1]), sum[2]+int(c[2])
+		}
+		n := max(1, len(box.colors))
+		palette = append(palette, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 255})
+	}
+	return palette
+}
+
+type colorBox struct {
+	colors  [][3]uint8
+	channel int // Channel with the widest range of values
+	spread  int // Range of values along that channel
+}
+
+func newColorBox(colors [][3]uint8) colorBox {
+	box := colorBox{colors: colors}
+	for ch := range 3 {
+		lo, hi := 255, 0
+		for _, c := range colors {
+			lo, hi = min(lo, int(c[ch])), max(hi, int(c[ch]))
+		}
+		if hi-lo > box.spread {
+			box.channel, box.spread = ch, hi-lo
+		}
+	}
+	return box
+}
+
+// Encodes frames as a looping animated PNG. Every frame is compressed by the
+// standard PNG encoder and its image data moved into APNG frame chunks.
+func encodeApng(w io.Writer, frames []*image.RGBA, fps float64) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to encode")
+	}
+
+	var ihdr []byte
+	var out bytes.Buffer
+	sequence := uint32(0)
+	be := binary.BigEndian
+	out.WriteString("\x89PNG\r\n\x1a\n")
+
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frame); err != nil {
+			return err
+		}
+		chunks, err := pngChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			ihdr = chunks["IHDR"][0]
+			writePngChunk(&out, "IHDR", ihdr)
+			actl := be.AppendUint32(nil, uint32(len(frames)))
+			actl = be.AppendUint32(actl, 0)
+			writePngChunk(&out, "acTL", actl)
+		} else if !bytes.Equal(chunks["IHDR"][0], ihdr) {
+			return errors.New("apng frames must share size and color type")
+		}
+
+		bounds := frame.Bounds()
+		fctl := be.AppendUint32(nil, sequence)
+		fctl = be.AppendUint32(fctl, uint32(bounds.Dx()))
+		fctl = be.AppendUint32(fctl, uint32(bounds.Dy()))
+		fctl = be.AppendUint32(fctl, 0)
+		fctl = be.AppendUint32(fctl, 0)
+		fctl = be.AppendUint16(fctl, 100)
+		fctl = be.AppendUint16(fctl, uint16(math.Round(fps*100)))
+		fctl = append(fctl, 0, 0)
+		writePngChunk(&out, "fcTL", fctl)
+		sequence++
+
+		for _, data := range chunks["IDAT"] {
+			if i == 0 {
+				writePngChunk(&out, "IDAT", data)
+				continue
+			}
+			writePngChunk(&out, "fdAT", append(be.AppendUint32(nil, sequence), data...))
+			sequence++
+		}
+	}
+
+	writePngChunk(&out, "IEND", nil)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func pngChunks(data []byte) (map[string][][]byte, error) {
+	chunks := map[string][][]byte{}
+	for p := 8; p+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		if p+12+length > len(data) {
+			return nil, errors.New("truncated png chunk")
+		}
+		kind := string(data[p+4 : p+8])
+		chunks[kind] = append(chunks[kind], data[p+8:p+8+length])
+		p += 12 + length
+	}
+	if len(chunks["IHDR"]) == 0 {
+		return nil, errors.New("png has no IHDR chunk")
+	}
+	return chunks, nil
+}
+
+func writePngChunk(w *bytes.Buffer, kind string, data []byte) {
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+	w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
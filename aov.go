@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+var aovNames = []string{"albedo", "normal", "depth", "position", "materialID", "objectID"}
+
+type aovBuffers struct {
+	albedo     []vec3    // Base color of the first surface hit, or the background color
+	normal     []vec3    // Shading normal of the first surface hit
+	depth      []float64 // Distance along the view direction to the first surface hit
+	position   []vec3    // World position of the first surface hit
+	materialID []int     // Index of the first hit material among the world's distinct materials
+	objectID   []int     // Index of the first hit object in the world
+}
+
+func aovBuffersInit(size int) *aovBuffers {
+	return &aovBuffers{
+		albedo:     make([]vec3, size),
+		normal:     make([]vec3, size),
+		depth:      make([]float64, size),
+		position:   make([]vec3, size),
+		materialID: make([]int, size),
+		objectID:   make([]int, size),
+	}
+}
+
+// Ray leaving the camera and the first surface it hit, with a nil material
+// if it hit none.
+type primaryHit struct {
+	r  ray
+	hr hitRecord
+}
+
+// Records the primary hits of a pixel's samples, averaging the continuous
+// buffers over the samples that hit and taking the IDs from the first one.
+func (a *aovBuffers) record(idx int, c *camera, samples []primaryHit, w *world) {
+	var albedo, normal, position vec3
+	depth := 0.0
+	hits := 0
+	a.materialID[idx], a.objectID[idx] = -1, -1
+
+	for _, sample := range samples {
+		hr := sample.hr
+		if hr.mat == nil {
+			albedo = albedo.add(background(sample.r))
+			continue
+		}
+		if hits == 0 {
+			a.materialID[idx] = w.materialID(hr.mat)
+			a.objectID[idx] = hr.objectID
+		}
+		hits++
+		albedo = albedo.add(hr.mat.baseColor())
+		normal = normal.add(hr.normal)
+		position = position.add(hr.point)
+		depth += hr.point.subtract(c.center).dot(c.w.scale(-1))
+	}
+
+	a.albedo[idx] = albedo.divide(float64(max(1, len(samples))))
+	if hits == 0 {
+		a.normal[idx] = vec3{0, 0, 0}
+		a.position[idx] = vec3{0, 0, 0}
+		a.depth[idx] = math.Inf(1)
+		return
+	}
+	if !normal.nearZero() {
+		normal = normal.normalize()
+	}
+	a.normal[idx] = normal
+	a.position[idx] = position.divide(float64(hits))
+	a.depth[idx] = depth / float64(hits)
+}
+
+// Maps an AOV to displayable RGBA bytes: albedo is gamma encoded like the
+// beauty pass, normals are remapped from [-1, 1], depth and position are
+// normalized over the hit range and IDs get a distinct color each.
+func (a *aovBuffers) pixels(name string) []byte {
+	size := len(a.albedo)
+	pixels := make([]byte, 4*size)
+	set := func(i int, col vec3) {
+		pixels[4*i] = uint8(math.Floor(255.999 * interval{0, 1}.clamp(col.x)))
+		pixels[4*i+1] = uint8(math.Floor(255.999 * interval{0, 1}.clamp(col.y)))
+		pixels[4*i+2] = uint8(math.Floor(255.999 * interval{0, 1}.clamp(col.z)))
+	}
+
+	switch name {
+	case "albedo":
+		for i, albedo := range a.albedo {
+			set(i, vec3{math.Sqrt(albedo.x), math.Sqrt(albedo.y), math.Sqrt(albedo.z)})
+		}
+	case "normal":
+		for i, normal := range a.normal {
+			set(i, normal.scale(0.5).add(vec3{0.5, 0.5, 0.5}))
+		}
+	case "depth":
+		far := 0.0
+		for _, d := range a.depth {
+			if !math.IsInf(d, 1) {
+				far = max(far, d)
+			}
+		}
+		for i, d := range a.depth {
+			g := 1.0
+			if !math.IsInf(d, 1) && far > 0 {
+				g = d / far
+			}
+			set(i, vec3{g, g, g})
+		}
+	case "position":
+		lo := vec3{math.Inf(1), math.Inf(1), math.Inf(1)}
+		hi := vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+		for i, p := range a.position {
+			if a.objectID[i] < 0 {
+				continue
+			}
+			lo = vec3{min(lo.x, p.x), min(lo.y, p.y), min(lo.z, p.z)}
+			hi = vec3{max(hi.x, p.x), max(hi.y, p.y), max(hi.z, p.z)}
+		}
+		extent := hi.subtract(lo)
+		for i, p := range a.position {
+			if a.objectID[i] < 0 {
+				continue
+			}
+			q := p.subtract(lo)
+			set(i, vec3{q.x / max(extent.x, 1e-9), q.y / max(extent.y, 1e-9), q.z / max(extent.z, 1e-9)})
+		}
+	case "materialID":
+		for i, id := range a.materialID {
+			set(i, idColor(id))
+		}
+	case "objectID":
+		for i, id := range a.objectID {
+			set(i, idColor(id))
+		}
+	}
+	for i := range size {
+		pixels[4*i+3] = 255
+	}
+	return pixels
+}
+
+// Returns the float channels of an AOV, named for EXR layers.
+func (a *aovBuffers) channels(name string) []imageChannel {
+	switch name {
+	case "albedo":
+		return vec3Channels("albedo.", a.albedo, "R", "G", "B")
+	case "normal":
+		return vec3Channels("normal.", a.normal, "X", "Y", "Z")
+	case "depth":
+		data := make([]float32, len(a.depth))
+		for i, d := range a.depth {
+			data[i] = float32(d)
+		}
+		return []imageChannel{{name: "depth.Z", data: data}}
+	case "position":
+		return vec3Channels("position.", a.position, "X", "Y", "Z")
+	case "materialID":
+		return []imageChannel{{name: "materialID.id", data: idData(a.materialID)}}
+	case "objectID":
+		return []imageChannel{{name: "objectID.id", data: idData(a.objectID)}}
+	}
+	return nil
+}
+
+func idData(ids []int) []float32 {
+	data := make([]float32, len(ids))
+	for i, id := range ids {
+		data[i] = float32(id)
+	}
+	return data
+}
+
+func idColor(id int) vec3 {
+	if id < 0 {
+		return vec3{0, 0, 0}
+	}
+	hue := math.Mod(float64(id)*0.618033988749895, 1)
+	return vec3{
+		0.5 + 0.5*math.Cos(2*math.Pi*hue),
+		0.5 + 0.5*math.Cos(2*math.Pi*(hue-1.0/3)),
+		0.5 + 0.5*math.Cos(2*math.Pi*(hue-2.0/3)),
+	}
+}
+
+func aovFileName(fileName, name string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "_" + name + ext
+}
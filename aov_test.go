@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAovRecord(t *testing.T) {
+	red := lambertian{albedo: vec3{1, 0, 0}}
+	blue := lambertian{albedo: vec3{0, 0, 1}}
+	w := &world{
+		objects: []hittable{
+			sphere{center: vec3{0, 0, -2}, radius: 0.5, mat: red},
+			sphere{center: vec3{1, 0, -2}, radius: 0.5, mat: red},
+			sphere{center: vec3{2, 0, -2}, radius: 0.5, mat: blue},
+		},
+	}
+	c := cameraInit(cameraParams{imgWidth: 1, aspectRatio: 1, verticalFov: 60, lookAt: vec3{0, 0, -1}, focalDistance: 1, antiAliasing: 1})
+	miss := primaryHit{r: ray{dir: vec3{0, 1, 0}}}
+	hit := func(objectID int, mat material, point, normal vec3) primaryHit {
+		return primaryHit{r: ray{dir: point}, hr: hitRecord{point: point, normal: normal, mat: mat, objectID: objectID}}
+	}
+
+	tests := []struct {
+		name           string
+		samples        []primaryHit
+		wantAlbedo     vec3
+		wantNormal     vec3
+		wantDepth      float64
+		wantPosition   vec3
+		wantMaterialID int
+		wantObjectID   int
+	}{
+		{
+			name:           "miss",
+			samples:        []primaryHit{miss},
+			wantAlbedo:     background(miss.r),
+			wantDepth:      math.Inf(1),
+			wantMaterialID: -1,
+			wantObjectID:   -1,
+		},
+		{
+			name:           "single hit",
+			samples:        []primaryHit{hit(2, blue, vec3{2, 0, -1.5}, vec3{0, 0, 1})},
+			wantAlbedo:     vec3{0, 0, 1},
+			wantNormal:     vec3{0, 0, 1},
+			wantDepth:      1.5,
+			wantPosition:   vec3{2, 0, -1.5},
+			wantMaterialID: 1,
+			wantObjectID:   2,
+		},
+		{
+			name: "hits averaged, IDs from the first",
+			samples: []primaryHit{
+				hit(1, red, vec3{1, 0, -1}, vec3{1, 0, 0}),
+				hit(2, blue, vec3{1, 0, -3}, vec3{0, 1, 0}),
+			},
+			wantAlbedo:     vec3{0.5, 0, 0.5},
+			wantNormal:     vec3{1, 1, 0}.normalize(),
+			wantDepth:      2,
+			wantPosition:   vec3{1, 0, -2},
+			wantMaterialID: 0,
+			wantObjectID:   1,
+		},
+		{
+			name:           "partial miss",
+			samples:        []primaryHit{miss, hit(0, red, vec3{0, 0, -1}, vec3{0, 0, 1})},
+			wantAlbedo:     background(miss.r).add(vec3{1, 0, 0}).divide(2),
+			wantNormal:     vec3{0, 0, 1},
+			wantDepth:      1,
+			wantPosition:   vec3{0, 0, -1},
+			wantMaterialID: 0,
+			wantObjectID:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := aovBuffersInit(1)
+			a.record(0, c, tt.samples, w)
+			if a.albedo[0].subtract(tt.wantAlbedo).l2() > 1e-9 {
+				t.Errorf("albedo %v, want %v", a.albedo[0], tt.wantAlbedo)
+			}
+			if a.normal[0].subtract(tt.wantNormal).l2() > 1e-9 {
+				t.Errorf("normal %v, want %v", a.normal[0], tt.wantNormal)
+			}
+			if a.depth[0] != tt.wantDepth && abs(a.depth[0]-tt.wantDepth) > 1e-9 {
+				t.Errorf("depth %g, want %g", a.depth[0], tt.wantDepth)
+			}
+			if a.position[0].subtract(tt.wantPosition).l2() > 1e-9 {
+				t.Errorf("position %v, want %v", a.position[0], tt.wantPosition)
+			}
+			if a.materialID[0] != tt.wantMaterialID || a.objectID[0] != tt.wantObjectID {
+				t.Errorf("material and object IDs %d and %d, want %d and %d", a.materialID[0], a.objectID[0], tt.wantMaterialID, tt.wantObjectID)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+)
+
+type aperture struct {
+	blades   int         // Number of straight diaphragm blades, circular if fewer than 3
+	rotation float64     // Rotation of the blades in degrees
+	mask     *image.Gray // Transmission mask over the aperture's bounding square, overrides blades
+	squeeze  float64     // Anamorphic squeeze, bokeh is this many times taller than wide, 1 if zero
+}
+
+// Returns a uniformly distributed point within the aperture shape, scaled to
+// fit the unit disk horizontally and vertically.
+func (a aperture) sample() vec3 {
+	var p vec3
+	switch {
+	case a.mask != nil:
+		p = a.sampleMask()
+	case a.blades >= 3:
+		p = a.samplePolygon()
+	default:
+		p = randomVecOnUnitDisk()
+	}
+	if a.squeeze > 0 {
+		p.x /= a.squeeze
+	}
+	return p
+}
+
+func (a aperture) samplePolygon() vec3 {
+	blade := min(a.blades-1, int(random()*float64(a.blades)))
+	angle := 2 * math.Pi / float64(a.blades)
+	start := deg2rad(a.rotation) + float64(blade)*angle
+	v1 := vec3{math.Cos(start), math.Sin(start), 0}
+	v2 := vec3{math.Cos(start + angle), math.Sin(start + angle), 0}
+
+	r1, r2 := random(), random()
+	if r1+r2 > 1 {
+		r1, r2 = 1-r1, 1-r2
+	}
+	return v1.scale(r1).add(v2.scale(r2))
+}
+
+// Rejection samples the mask, treating its gray level as the probability of
+// light passing through. Falls back to the center for nearly opaque masks.
+func (a aperture) sampleMask() vec3 {
+	bounds := a.mask.Bounds()
+	for range 64 {
+		x, y := randomIn(-1, 1), randomIn(-1, 1)
+		px := bounds.Min.X + min(bounds.Dx()-1, int((x+1)/2*float64(bounds.Dx())))
+		py := bounds.Min.Y + min(bounds.Dy()-1, int((1-y)/2*float64(bounds.Dy())))
+		if random()*255 < float64(a.mask.GrayAt(px, py).Y) {
+			return vec3{x, y, 0}
+		}
+	}
+	return vec3{0, 0, 0}
+}
+
+func loadApertureMask(path string) (*image.Gray, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	mask := image.NewGray(img.Bounds())
+	draw.Draw(mask, mask.Bounds(), img, img.Bounds().Min, draw.Src)
+	return mask, nil
+}
@@ -1,299 +1,587 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"image"
-	"image/color"
-	"image/png"
+	"io"
 	"math"
-	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type camera struct {
-	aspectRatio                 float64        // Ratio of image width over height
-	imgWidth                    int            // Rendered image width in pixel count
-	imgHeight                   int            // Rendered image height
-	center                      vec3           // Camera center
-	lookAt                      vec3           // Point in space where the camera is looking
-	upDir                       vec3           // Up direction
-	viewportWidth               float64        // Width of the virtual viewport
-	viewportHeight              float64        // Height of the virtual viewport
-	u, v, w                     vec3           // Camera frame of reference versors
-	pitch                       float64        // Pitch angle
-	verticalFov                 float64        // Vertical view angle
-	defocusAngle                float64        // Variation angle of rays through each pixel
-	focalDistance               float64        // Distance from camera lookfrom point to plane of perfect focus
-	defocusDiskU                vec3           // Defocus disk horizontal radius
-	defocusDiskV                vec3           // Defocus disk vertical radius
-	viewportUpperLeft           vec3           // Location of top-left corner of pixel 0, 0
-	interPixelDeltaHorizontal   vec3           // Offset to pixel to the right
-	interPixelDeltaVertical     vec3           // Offset to pixel below
-	antiAliasing                int            // Level of antialiasing
-	antiAliasingDeltaHorizontal vec3           // Offset to sub-pixel sample to the right
-	antiAliasingDeltaVertical   vec3           // Offset to sub-pixel sample below
-	maxDepth                    int            // Maximum number of ray bounces into scene
-	pixels                      []byte         // Flattened image last rendered by the camera
-	renderJobQueue              chan renderJob // Render task queue to be split between workers
+	aspectRatio            float64      // Ratio of image width over height
+	imgWidth               int          // Rendered image width in pixel count
+	imgHeight              int          // Rendered image height
+	center                 vec3         // Camera center
+	lookAt                 vec3         // Point in space where the camera is looking
+	upDir                  vec3         // Up direction
+	viewportWidth          float64      // Width of the virtual viewport
+	viewportHeight         float64      // Height of the virtual viewport
+	u, v, w                vec3         // Camera frame of reference versors
+	pitch                  float64      // Pitch angle
+	freeFly                bool         // Whether the camera turns around its own axes, allowing roll and looking straight up
+	orientation            quaternion   // Rotation from camera space to world space while in free fly mode
+	verticalFov            float64      // Vertical view angle
+	defocusAngle           float64      // Variation angle of rays through each pixel
+	focalDistance          float64      // Distance from camera lookfrom point to plane of perfect focus
+	defocusDiskU           vec3         // Defocus disk horizontal radius
+	defocusDiskV           vec3         // Defocus disk vertical radius
+	aperture               aperture     // Shape of the lens aperture, giving the shape of out of focus highlights
+	tilt                   float64      // Rotation in degrees of the plane of focus around the horizontal axis
+	shiftHorizontal        float64      // Lens shift to the right in viewport widths
+	shiftVertical          float64      // Lens shift upwards in viewport heights
+	model                  cameraModel  // Projection used to generate primary rays
+	stereo                 stereoLayout // How left and right eye views are packed into the image
+	interpupillaryDistance float64      // Distance between the left and right eyes
+	convergence            float64      // Distance to the plane of zero parallax, focal distance if zero
+	antiAliasing           int          // Level of antialiasing
+	filter                 filter       // Pixel reconstruction filter
+	maxDepth               int          // Maximum number of ray bounces into scene
+	film                   *film        // Filter-weighted sample accumulation of the last render
+	aovs                   *aovBuffers  // Arbitrary output variables of the last render, nil if disabled
+	filmMutex              sync.Mutex   // Guards film and stats while workers merge their results
+	stats                  renderStats  // Statistics of the last render
+	tilesDone              int          // Tiles of the current render merged into the film
+	passes                 int          // Passes of samples accumulated in the film
+	variance               passVariance // Luminance of the passes accumulated in the film, estimating its noise
+	coordinator            *coordinator // Hands the tiles of each pass out to worker processes, nil to render them locally
+	progress               progressFunc // Called with the number of rendered tiles after each one, nil to not report progress
+	denoise                bool         // Whether to denoise the image after rendering
+	denoiser               denoiser     // Denoising filter settings
+	image                  []vec3       // Linear color image last rendered by the camera
+	pixels                 []byte       // Flattened image last rendered by the camera
+	imageOptions           imageOptions // Settings used when encoding screenshots
+	workers                int          // Number of goroutines rendering tiles
 }
 
 type cameraParams struct {
-	aspectRatio   float64 // Ratio of image width over height
-	imgWidth      int     // Rendered image width in pixel count
-	lookFrom      vec3    // Point in space where the camera eye is located
-	lookAt        vec3    // Point in space where the camera is looking
-	verticalFov   float64 // Vertical view angle
-	defocusAngle  float64 // Variation angle of rays through each pixel
-	focalDistance float64 // Distance from camera lookfrom point to plane of perfect focus
-	antiAliasing  int     // Level of antialiasing
-	maxDepth      int     // Maximum number of ray bounces into scene
-}
-
-type renderJob struct {
-	startRow int
-	endRow   int
-	wg       *sync.WaitGroup
-	world    *world
+	aspectRatio            float64      // Ratio of image width over height
+	imgWidth               int          // Rendered image width in pixel count
+	lookFrom               vec3         // Point in space where the camera eye is located
+	lookAt                 vec3         // Point in space where the camera is looking
+	verticalFov            float64      // Vertical view angle
+	defocusAngle           float64      // Variation angle of rays through each pixel
+	focalDistance          float64      // Distance from camera lookfrom point to plane of perfect focus
+	freeFly                bool         // Whether the camera turns around its own axes, allowing roll and looking straight up
+	roll                   float64      // Rotation in degrees around the view direction, implies free fly mode
+	aperture               aperture     // Shape of the lens aperture, circular if zero
+	tilt                   float64      // Rotation in degrees of the plane of focus around the horizontal axis
+	shiftHorizontal        float64      // Lens shift to the right in viewport widths
+	shiftVertical          float64      // Lens shift upwards in viewport heights
+	antiAliasing           int          // Level of antialiasing
+	filter                 filter       // Pixel reconstruction filter, a half-pixel box filter if nil
+	maxDepth               int          // Maximum number of ray bounces into scene
+	model                  cameraModel  // Projection used to generate primary rays, perspective if nil
+	stereo                 stereoLayout // How left and right eye views are packed into the image
+	interpupillaryDistance float64      // Distance between the left and right eyes
+	convergence            float64      // Distance to the plane of zero parallax, focal distance if zero
+	aovs                   bool         // Whether to also render first-hit albedo, normal, depth, position and IDs
+	denoise                bool         // Whether to denoise the image after rendering, implies aovs
+	imageOptions           imageOptions // Settings used when encoding screenshots, defaults if zero
+	workers                int          // Number of goroutines rendering tiles, the number of CPUs if zero
 }
 
 func cameraInit(params cameraParams) *camera {
-	center := params.lookFrom
-	upDir := vec3{0, 1, 0}
-
 	imgHeight := int(float64(params.imgWidth) / params.aspectRatio)
-	viewportHeight := 2 * math.Tan(deg2rad(params.verticalFov)/2) * params.focalDistance
-	viewportWidth := viewportHeight * (float64(params.imgWidth) / float64(imgHeight))
-
-	w := center.subtract(params.lookAt).normalize()
-	u := upDir.cross(w).normalize()
-	v := w.cross(u)
-	pitch := w.angle(upDir)
 
-	viewportEdgeHorizontal := u.scale(viewportWidth)
-	viewportEdgeVertical := v.scale(-viewportHeight)
-	interPixelDeltaHorizontal := viewportEdgeHorizontal.divide(float64(params.imgWidth))
-	interPixelDeltaVertical := viewportEdgeVertical.divide(float64(imgHeight))
-
-	viewportUpperLeft := center.
-		subtract(w.scale(params.focalDistance)).
-		subtract((viewportEdgeHorizontal.add(viewportEdgeVertical)).scale(0.5))
-
-	defocusRadius := params.focalDistance * math.Tan(deg2rad(params.defocusAngle/2))
-	defocusDiskU := u.scale(defocusRadius)
-	defocusDiskV := v.scale(defocusRadius)
+	pixelFilter := params.filter
+	if pixelFilter == nil {
+		pixelFilter = boxFilter{radius: 0.5}
+	}
 
-	pixels := make([]byte, 4*params.imgWidth*imgHeight)
-	for i := range pixels {
-		pixels[i] = 255
+	model := params.model
+	if model == nil {
+		model = perspective{}
 	}
 
-	antiAliasingDeltaHorizontal := interPixelDeltaHorizontal.divide(float64(params.antiAliasing + 1))
-	antiAliasingDeltaVertical := interPixelDeltaVertical.divide(float64(params.antiAliasing + 1))
+	options := params.imageOptions
+	if options == (imageOptions{}) {
+		options = defaultImageOptions
+	}
 
 	c := &camera{
-		aspectRatio:                 params.aspectRatio,
-		imgWidth:                    params.imgWidth,
-		imgHeight:                   imgHeight,
-		center:                      center,
-		lookAt:                      params.lookAt,
-		upDir:                       upDir,
-		viewportWidth:               viewportWidth,
-		viewportHeight:              viewportHeight,
-		u:                           u,
-		v:                           v,
-		w:                           w,
-		pitch:                       pitch,
-		verticalFov:                 params.verticalFov,
-		defocusAngle:                params.defocusAngle,
-		focalDistance:               params.focalDistance,
-		defocusDiskU:                defocusDiskU,
-		defocusDiskV:                defocusDiskV,
-		viewportUpperLeft:           viewportUpperLeft,
-		interPixelDeltaHorizontal:   interPixelDeltaHorizontal,
-		interPixelDeltaVertical:     interPixelDeltaVertical,
-		antiAliasing:                params.antiAliasing,
-		antiAliasingDeltaHorizontal: antiAliasingDeltaHorizontal,
-		antiAliasingDeltaVertical:   antiAliasingDeltaVertical,
-		maxDepth:                    params.maxDepth,
-		pixels:                      pixels,
-	}
-
-	numWorkers := runtime.NumCPU()
-	c.renderJobQueue = make(chan renderJob, numWorkers)
-	for range numWorkers {
-		go func() {
-			for job := range c.renderJobQueue {
-				for y := job.startRow; y < job.endRow; y++ {
-					for x := range c.imgWidth {
-						c.renderPixel(x, y, job.world)
-					}
-				}
-				job.wg.Done()
-			}
-		}()
+		aspectRatio:            params.aspectRatio,
+		imgWidth:               params.imgWidth,
+		imgHeight:              imgHeight,
+		upDir:                  vec3{0, 1, 0},
+		defocusAngle:           params.defocusAngle,
+		aperture:               params.aperture,
+		tilt:                   params.tilt,
+		shiftHorizontal:        params.shiftHorizontal,
+		shiftVertical:          params.shiftVertical,
+		antiAliasing:           params.antiAliasing,
+		filter:                 pixelFilter,
+		maxDepth:               params.maxDepth,
+		model:                  model,
+		stereo:                 params.stereo,
+		interpupillaryDistance: params.interpupillaryDistance,
+		convergence:            params.convergence,
+		film:                   filmInit(0, 0, params.imgWidth, imgHeight),
+		denoiser:               defaultDenoiser,
+		image:                  make([]vec3, params.imgWidth*imgHeight),
+		pixels:                 opaquePixels(params.imgWidth * imgHeight),
+		imageOptions:           options,
+	}
+	c.freeFly = params.freeFly
+	c.setPose(keyframe{
+		lookFrom:      params.lookFrom,
+		lookAt:        params.lookAt,
+		verticalFov:   params.verticalFov,
+		focalDistance: params.focalDistance,
+		roll:          params.roll,
+	})
+
+	if params.aovs {
+		c.aovs = aovBuffersInit(params.imgWidth * imgHeight)
+	}
+	c.setDenoise(params.denoise)
+
+	c.workers = params.workers
+	if c.workers <= 0 {
+		c.workers = runtime.NumCPU()
 	}
 
 	return c
 }
 
-func rayColor(r ray, depth int, w *world) vec3 {
+func rayColor(r ray, depth int, w *world, counts *rayCounts) vec3 {
+	col, _ := traceRay(r, depth, w, counts)
+	return col
+}
+
+// Returns the color seen along r and the first surface it hits, which has a
+// nil material if it hits none.
+func traceRay(r ray, depth int, w *world, counts *rayCounts) (vec3, hitRecord) {
+	var hr hitRecord
 	if depth <= 0 {
-		return vec3{0, 0, 0}
+		return vec3{0, 0, 0}, hr
 	}
 
-	var hr hitRecord
+	counts.intersectionTests += int64(len(w.objects))
 	if w.hit(r, interval{0.0001, math.Inf(1)}, &hr) {
+		first := hr
 		var rOut ray
 		var colorAttenuation vec3
 		if hr.mat.scatter(r, &hr, &colorAttenuation, &rOut) {
-			return rayColor(rOut, depth-1, w).multiply(colorAttenuation)
+			counts.secondaryRays++
+			return rayColor(rOut, depth-1, w, counts).multiply(colorAttenuation), first
 		}
-		return vec3{0, 0, 0}
+		return vec3{0, 0, 0}, first
 	}
 
+	return background(r), hr
+}
+
+func background(r ray) vec3 {
 	unitDir := r.dir.normalize()
 	a := 0.5 * (unitDir.y + 1.0)
 	return vec3{1.0, 1.0, 1.0}.scale(1.0 - a).add(vec3{0.5, 0.7, 1.0}.scale(a))
 }
 
-func (c *camera) renderPixel(x, y int, w *world) {
-	pixelCorner := c.viewportUpperLeft.
-		add(c.interPixelDeltaHorizontal.scale(float64(x))).
-		add(c.interPixelDeltaVertical.scale(float64(y)))
+// Renders the pixels within tile and merges them into the camera's film.
+// Gives up without merging if ctx is cancelled.
+func (c *camera) renderTile(ctx context.Context, tile image.Rectangle, w *world) {
+	f, counts := c.traceTile(ctx, tile, c.passes, w)
+	if ctx.Err() == nil {
+		c.mergeTile(f, counts)
+	}
+}
+
+// Renders the pixels within tile into a local film, which also collects the
+// filter footprint reaching past the tile, as the pass after the given number
+// of accumulated ones.
+func (c *camera) traceTile(ctx context.Context, tile image.Rectangle, pass int, w *world) (*film, rayCounts) {
+	margin := int(math.Ceil(c.filter.support()))
+	filmBounds := tile.Inset(-margin).Intersect(image.Rect(0, 0, c.imgWidth, c.imgHeight))
+	f := filmInit(filmBounds.Min.X, filmBounds.Min.Y, filmBounds.Dx(), filmBounds.Dy())
+
+	var counts rayCounts
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		if ctx.Err() != nil {
+			break
+		}
+		for x := tile.Min.X; x < tile.Max.X; x++ {
+			c.renderPixel(x, y, pass, w, f, &counts)
+		}
+	}
+	return f, counts
+}
+
+func (c *camera) mergeTile(f *film, counts rayCounts) {
+	c.filmMutex.Lock()
+	defer c.filmMutex.Unlock()
+	c.film.merge(f)
+	c.stats.merge(counts)
+	c.tilesDone++
+	if c.progress != nil {
+		c.progress(c.tilesDone, c.stats.tiles)
+	}
+}
+
+func (c *camera) renderPixel(x, y, pass int, w *world, f *film, counts *rayCounts) {
+	var hits []primaryHit
+	if c.aovs != nil {
+		hits = make([]primaryHit, 0, c.antiAliasing*c.antiAliasing)
+	}
 
-	rayCol := vec3{0, 0, 0}
 	for j := 1; j < c.antiAliasing+1; j++ {
 		for i := 1; i < c.antiAliasing+1; i++ {
-			viewportPoint := pixelCorner.
-				add(c.antiAliasingDeltaHorizontal.scale(float64(i))).
-				add(c.antiAliasingDeltaVertical.scale(float64(j)))
-			rayOri := c.center
-			if c.defocusAngle > 0 {
-				rayOri = c.randomPointOnDefocusDisk()
+			sx := float64(x) + float64(i)/float64(c.antiAliasing+1)
+			sy := float64(y) + float64(j)/float64(c.antiAliasing+1)
+			if pass > 0 {
+				// Later passes jitter samples within their strata to add new information
+				sx = float64(x) + (float64(i-1)+random())/float64(c.antiAliasing)
+				sy = float64(y) + (float64(j-1)+random())/float64(c.antiAliasing)
+			}
+			eye, s, t := c.viewCoordinates(sx, sy)
+			bounds := c.viewBounds(eye)
+			r, weight := c.model.generateRay(c, s, t, eye)
+			if weight == 0 {
+				f.splat(c.filter, sx, sy, vec3{0, 0, 0}, bounds)
+				continue
+			}
+			counts.primaryRays++
+			col, hr := traceRay(r, c.maxDepth, w, counts)
+			f.splat(c.filter, sx, sy, col.scale(weight), bounds)
+			if c.aovs != nil {
+				hits = append(hits, primaryHit{r, hr})
 			}
-			rayDir := viewportPoint.subtract(rayOri)
-			rayCol = rayCol.add(rayColor(ray{ori: rayOri, dir: rayDir}, c.maxDepth, w))
 		}
 	}
 
-	idx := 4 * (y*c.imgWidth + x)
-	color := rayCol.divide(float64(c.antiAliasing) * float64(c.antiAliasing))
-	c.pixels[idx] = uint8(math.Floor(255.999 * math.Sqrt(color.x)))
-	c.pixels[idx+1] = uint8(math.Floor(255.999 * math.Sqrt(color.y)))
-	c.pixels[idx+2] = uint8(math.Floor(255.999 * math.Sqrt(color.z)))
+	if c.aovs != nil {
+		c.aovs.record(y*c.imgWidth+x, c, hits, w)
+	}
+}
+
+func (c *camera) develop() {
+	start := time.Now()
+	for y := range c.imgHeight {
+		for x := range c.imgWidth {
+			c.image[y*c.imgWidth+x] = c.film.resolve(x, y)
+		}
+	}
+
+	if c.denoise {
+		denoiseStart := time.Now()
+		c.image = c.denoiser.apply(c.image, c.imgWidth, c.imgHeight, c.aovs)
+		c.stats.denoiseTime = time.Since(denoiseStart)
+	}
+
+	for i, color := range c.image {
+		c.pixels[4*i] = linearToByte(color.x)
+		c.pixels[4*i+1] = linearToByte(color.y)
+		c.pixels[4*i+2] = linearToByte(color.z)
+	}
+	c.stats.developTime = time.Since(start) - c.stats.denoiseTime
+	c.stats.passes = c.passes
+	c.stats.samplesPerPixel = c.passes * c.antiAliasing * c.antiAliasing
+	c.stats.noise = c.variance.estimate()
+}
+
+// Changes the rendered image width keeping the aspect ratio, reallocating
+// the buffers sized after the image.
+func (c *camera) resize(imgWidth int) {
+	imgHeight := max(1, int(float64(imgWidth)/c.aspectRatio))
+	if imgWidth == c.imgWidth && imgHeight == c.imgHeight {
+		return
+	}
+	c.imgWidth, c.imgHeight = imgWidth, imgHeight
+	c.film = filmInit(0, 0, imgWidth, imgHeight)
+	c.image = make([]vec3, imgWidth*imgHeight)
+	c.pixels = opaquePixels(imgWidth * imgHeight)
+	if c.aovs != nil {
+		c.aovs = aovBuffersInit(imgWidth * imgHeight)
+	}
+	c.updateViewport()
+}
+
+func opaquePixels(size int) []byte {
+	pixels := make([]byte, 4*size)
+	for i := range pixels {
+		pixels[i] = 255
+	}
+	return pixels
+}
+
+func (c *camera) setDenoise(denoise bool) {
+	if denoise && c.aovs == nil {
+		c.aovs = aovBuffersInit(c.imgWidth * c.imgHeight)
+	}
+	c.denoise = denoise
+}
+
+func linearToByte(x float64) uint8 {
+	return uint8(math.Floor(255.999 * math.Sqrt(interval{0, 1}.clamp(x))))
 }
 
 func (c *camera) render(w *world) {
-	numWorkers := runtime.NumCPU()
-	rowsPerWorker := c.imgHeight / numWorkers
+	c.renderContext(context.Background(), w)
+}
+
+// Renders the world from scratch, discarding the samples of previous renders.
+func (c *camera) renderContext(ctx context.Context, w *world) error {
+	c.passes = 0
+	return c.renderPass(ctx, w)
+}
 
-	var wg sync.WaitGroup
+// Adds another pass of samples to the film, refining the image.
+func (c *camera) refine(ctx context.Context, w *world) error {
+	return c.renderPass(ctx, w)
+}
 
-	for i := 0; i < numWorkers; i++ {
-		startRow := i * rowsPerWorker
-		endRow := startRow + rowsPerWorker
-		if i == numWorkers-1 {
-			endRow = c.imgHeight
-		}
+// Renders a pass of samples in small tiles pulled by the workers from a
+// shared queue in spiral order, so no worker idles while others finish long
+// tiles. Returns the context's error without developing the image if it is
+// cancelled, leaving the film with only the samples of completed passes.
+func (c *camera) renderPass(ctx context.Context, w *world) error {
+	tiles := spiralTiles(c.imgWidth, c.imgHeight, tileSize)
+	accumulated := c.film
+	if c.passes == 0 {
+		c.film.clear()
+		c.variance = passVariance{}
+	} else {
+		c.film = filmInit(0, 0, c.imgWidth, c.imgHeight)
+	}
+	c.stats = renderStats{tiles: len(tiles)}
+	c.tilesDone = 0
+	if model, ok := c.model.(preparedCameraModel); ok {
+		model.prepare(c)
+	}
 
-		wg.Add(1)
-		c.renderJobQueue <- renderJob{
-			startRow: startRow,
-			endRow:   endRow,
-			wg:       &wg,
-			world:    w,
+	start := time.Now()
+	if c.coordinator != nil {
+		c.coordinator.renderTiles(ctx, c, tiles)
+	} else {
+		var next atomic.Int64
+		var wg sync.WaitGroup
+		for range c.workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					i := int(next.Add(1)) - 1
+					if i >= len(tiles) {
+						return
+					}
+					c.renderTile(ctx, tiles[i], w)
+				}
+			}()
 		}
+		wg.Wait()
 	}
+	c.stats.traceTime = time.Since(start)
 
-	wg.Wait()
+	if ctx.Err() == nil {
+		c.variance.add(c.film)
+	}
+	if c.film != accumulated {
+		if ctx.Err() == nil {
+			accumulated.merge(c.film)
+		}
+		c.film = accumulated
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.passes++
+	c.develop()
+	return nil
 }
 
 func (c *camera) randomPointOnDefocusDisk() vec3 {
-	v := randomVecOnUnitDisk()
+	v := c.aperture.sample()
 	return c.center.add(c.defocusDiskU.scale(v.x)).add(c.defocusDiskV.scale(v.y))
 }
 
-func (c *camera) update(movement vec3, fov, yaw, pitch float64) {
-	relativeMovement := c.u.scale(movement.x).add(c.upDir.scale(movement.y)).add(c.w.scale(movement.z))
-	c.center = c.center.add(relativeMovement)
-	c.viewportUpperLeft = c.viewportUpperLeft.add(relativeMovement)
-
+// Moves the camera by movement, in camera space, and turns it by yaw, pitch
+// and roll radians. Roll only applies in free fly mode, otherwise the camera
+// stays upright and can't pitch past straight up or down.
+func (c *camera) update(movement vec3, fov, yaw, pitch, roll float64) {
 	c.verticalFov = interval{0.001, 179.999}.clamp(c.verticalFov + fov)
-	c.viewportHeight = 2 * math.Tan(deg2rad(c.verticalFov)/2) * c.focalDistance
-	c.viewportWidth = c.viewportHeight * (float64(c.imgWidth) / float64(c.imgHeight))
 
-	newPitch := interval{0.001, math.Pi - 0.001}.clamp(c.pitch + pitch)
-	c.w = c.w.rotateAroundAxis(c.u, newPitch-c.pitch).rotateAroundAxis(c.upDir, yaw)
-	c.u = c.upDir.cross(c.w).normalize()
-	c.v = c.w.cross(c.u)
-	c.pitch = newPitch
+	if c.freeFly {
+		c.center = c.center.add(c.u.scale(movement.x)).add(c.v.scale(movement.y)).add(c.w.scale(movement.z))
+		c.orientation = c.orientation.
+			multiply(quaternionFromAxisAngle(vec3{0, 1, 0}, yaw)).
+			multiply(quaternionFromAxisAngle(vec3{1, 0, 0}, pitch)).
+			multiply(quaternionFromAxisAngle(vec3{0, 0, 1}, roll)).
+			normalize()
+		c.u = c.orientation.rotate(vec3{1, 0, 0})
+		c.v = c.orientation.rotate(vec3{0, 1, 0})
+		c.w = c.orientation.rotate(vec3{0, 0, 1})
+	} else {
+		relativeMovement := c.u.scale(movement.x).add(c.upDir.scale(movement.y)).add(c.w.scale(movement.z))
+		c.center = c.center.add(relativeMovement)
+
+		newPitch := interval{0.001, math.Pi - 0.001}.clamp(c.pitch + pitch)
+		c.w = c.w.rotateAroundAxis(c.u, newPitch-c.pitch).rotateAroundAxis(c.upDir, yaw)
+		c.u = c.upDir.cross(c.w).normalize()
+		c.v = c.w.cross(c.u)
+		c.pitch = newPitch
+	}
 
-	viewportEdgeHorizontal := c.u.scale(c.viewportWidth)
-	viewportEdgeVertical := c.v.scale(-c.viewportHeight)
-	c.interPixelDeltaHorizontal = viewportEdgeHorizontal.divide(float64(c.imgWidth))
-	c.interPixelDeltaVertical = viewportEdgeVertical.divide(float64(c.imgHeight))
+	c.updateViewport()
+	c.updateDefocus()
+}
 
-	c.viewportUpperLeft = c.center.
-		subtract(c.w.scale(c.focalDistance)).
-		subtract((viewportEdgeHorizontal.add(viewportEdgeVertical)).scale(0.5))
+// Switches free fly mode, leveling the horizon when leaving it.
+func (c *camera) setFreeFly(freeFly bool) {
+	c.freeFly = freeFly
+	if freeFly {
+		c.orientation = quaternionFromBasis(c.u, c.v, c.w)
+		return
+	}
+	heading := c.w.subtract(c.upDir.scale(c.w.dot(c.upDir)))
+	if heading.nearZero() {
+		heading = c.v.subtract(c.upDir.scale(c.v.dot(c.upDir)))
+	}
+	c.pitch = interval{0.001, math.Pi - 0.001}.clamp(c.w.angle(c.upDir))
+	c.u = c.upDir.cross(heading).normalize()
+	c.w = c.upDir.rotateAroundAxis(c.u, c.pitch)
+	c.v = c.w.cross(c.u)
+	c.updateDefocus()
 }
 
-func (c *camera) screenshot(directory, fileName string) error {
-	ext := filepath.Ext(fileName)
-	path := filepath.Join(directory, fileName)
+func (c *camera) setFocus(focalDistance, defocusAngle float64) {
+	c.focalDistance = max(0.01, focalDistance)
+	c.defocusAngle = interval{0, 45}.clamp(defocusAngle)
+	c.updateViewport()
+	c.updateDefocus()
+}
 
-	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
-	if err != nil {
-		return err
+// Returns the closest hit seen from the eye at normalized view coordinates s
+// and t, trying a few rays since camera models may not generate one for every
+// sample.
+func (c *camera) pick(w *world, eye, s, t float64) (hitRecord, bool) {
+	var hr hitRecord
+	for range 16 {
+		r, weight := c.model.generateRay(c, s, t, eye)
+		if weight == 0 {
+			continue
+		}
+		return hr, w.hit(r, interval{0.0001, math.Inf(1)}, &hr)
 	}
+	return hr, false
+}
 
-	switch ext {
-	case ".ppm":
-		err = savePpm(c.pixels, c.imgWidth, c.imgHeight, path)
-	case ".png":
-		err = savePng(c.pixels, c.imgWidth, c.imgHeight, path)
+// Returns the distance along the view direction to the closest object seen
+// at normalized view coordinates s and t.
+func (c *camera) focusDistanceAt(w *world, s, t float64) (float64, bool) {
+	hr, ok := c.pick(w, 0, s, t)
+	if !ok {
+		return 0, false
 	}
-	return err
+	return c.center.subtract(hr.point).dot(c.w), true
 }
 
-func savePpm(pixels []byte, imgWidth, imgHeight int, path string) error {
-	file, err := os.Create(path)
-	if err != nil {
-		return err
+func (c *camera) pose() keyframe {
+	k := keyframe{
+		lookFrom:      c.center,
+		lookAt:        c.center.subtract(c.w),
+		verticalFov:   c.verticalFov,
+		focalDistance: c.focalDistance,
 	}
-	defer file.Close()
+	if c.freeFly {
+		u, v := c.levelBasis(c.w)
+		k.roll = rad2deg(math.Atan2(c.u.dot(v), c.u.dot(u)))
+	}
+	return k
+}
 
-	if _, err := file.WriteString(fmt.Sprintf("P3\n%d %d\n255\n", imgWidth, imgHeight)); err != nil {
-		return err
+// Points the camera as in the keyframe. A rolled pose switches to free fly
+// mode, as only it keeps the roll.
+func (c *camera) setPose(k keyframe) {
+	c.center = k.lookFrom
+	c.lookAt = k.lookAt
+	c.verticalFov = k.verticalFov
+	c.focalDistance = k.focalDistance
+
+	c.w = k.lookFrom.subtract(k.lookAt).normalize()
+	u, v := c.levelBasis(c.w)
+	roll := deg2rad(k.roll)
+	c.u = u.scale(math.Cos(roll)).add(v.scale(math.Sin(roll)))
+	c.v = c.w.cross(c.u)
+	c.pitch = c.w.angle(c.upDir)
+	if k.roll != 0 {
+		c.freeFly = true
 	}
-	for y := range imgHeight {
-		for x := range imgWidth {
-			idx := 4 * (y*imgWidth + x)
-			if _, err := file.WriteString(fmt.Sprintf("%d %d %d\n", pixels[idx], pixels[idx+1], pixels[idx+2])); err != nil {
-				return err
-			}
-		}
+	if c.freeFly {
+		c.orientation = quaternionFromBasis(c.u, c.v, c.w)
 	}
-	return nil
-}
 
-func savePng(pixels []byte, imgWidth, imgHeight int, path string) error {
-	img := image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight))
+	c.updateViewport()
+	c.updateDefocus()
+}
 
-	for y := range imgHeight {
-		for x := range imgWidth {
-			idx := 4 * (y*imgWidth + x)
-			img.Set(x, y, color.RGBA{R: pixels[idx], G: pixels[idx+1], B: pixels[idx+2], A: 255})
-		}
+// Returns the right and up directions of an unrolled camera looking along -w.
+// Looking straight up or down, where any heading is level, the right
+// direction is the x axis.
+func (c *camera) levelBasis(w vec3) (u, v vec3) {
+	u = c.upDir.cross(w)
+	if u.l2() < 1e-9 {
+		u = vec3{1, 0, 0}.subtract(w.scale(w.x))
 	}
+	u = u.normalize()
+	return u, w.cross(u)
+}
+
+func (c *camera) updateDefocus() {
+	defocusRadius := c.focalDistance * math.Tan(deg2rad(c.defocusAngle/2))
+	c.defocusDiskU = c.u.scale(defocusRadius)
+	c.defocusDiskV = c.v.scale(defocusRadius)
+}
+
+func (c *camera) updateViewport() {
+	c.viewportHeight = 2 * math.Tan(deg2rad(c.verticalFov)/2) * c.focalDistance
+	viewWidth, viewHeight := c.viewSize()
+	c.viewportWidth = c.viewportHeight * viewWidth / viewHeight
+}
 
-	file, err := os.Create(path)
+func (c *camera) screenshot(directory, fileName string) error {
+	file, err := createImageFile(filepath.Join(directory, fileName))
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	return png.Encode(file, img)
+	return c.encode(file, filepath.Ext(fileName))
+}
+
+func (c *camera) encode(w io.Writer, format string) error {
+	channels := vec3Channels("", c.image, "R", "G", "B")
+	if format == ".exr" && c.aovs != nil {
+		for _, name := range aovNames {
+			channels = append(channels, c.aovs.channels(name)...)
+		}
+	}
+	return encodeImage(w, format, c.pixels, channels, c.imgWidth, c.imgHeight, c.imageOptions)
+}
+
+func (c *camera) screenshotAovs(directory, fileName string) error {
+	if c.aovs == nil {
+		return errors.New("camera was not set up to render AOVs")
+	}
+
+	// AOV PNGs are 8-bit previews, the raw data is available as PFM or EXR.
+	options := c.imageOptions
+	options.pngDepth16 = false
+
+	for _, name := range aovNames {
+		file, err := createImageFile(filepath.Join(directory, aovFileName(fileName, name)))
+		if err != nil {
+			return err
+		}
+		err = encodeImage(file, filepath.Ext(fileName), c.aovs.pixels(name), c.aovs.channels(name), c.imgWidth, c.imgHeight, options)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
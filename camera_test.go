@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSetPoseRoll(t *testing.T) {
+	tests := []struct {
+		name        string
+		freeFly     bool
+		pose        keyframe
+		wantFreeFly bool
+	}{
+		{"level", false, keyframe{lookAt: vec3{0, 0, -1}, verticalFov: 60, focalDistance: 1}, false},
+		{"rolled", false, keyframe{lookAt: vec3{0, 0, -1}, verticalFov: 60, focalDistance: 1, roll: 30}, true},
+		{"rolled backwards", true, keyframe{lookAt: vec3{1, 1, 1}, verticalFov: 60, focalDistance: 1, roll: -120}, true},
+		{"straight up", true, keyframe{lookAt: vec3{0, 1, 0}, verticalFov: 60, focalDistance: 1, roll: 45}, true},
+		{"straight down", false, keyframe{lookAt: vec3{0, -1, 0}, verticalFov: 60, focalDistance: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cameraInit(cameraParams{imgWidth: 8, aspectRatio: 1, verticalFov: 60, lookAt: vec3{0, 0, -1}, focalDistance: 1, antiAliasing: 1, freeFly: tt.freeFly})
+			c.setPose(tt.pose)
+			if c.freeFly != tt.wantFreeFly {
+				t.Errorf("free fly is %v, want %v", c.freeFly, tt.wantFreeFly)
+			}
+			for _, axis := range []vec3{c.u, c.v, c.w} {
+				if math.IsNaN(axis.l2()) || abs(axis.l2()-1) > 1e-9 {
+					t.Fatalf("basis %v, %v, %v is not made of unit vectors", c.u, c.v, c.w)
+				}
+			}
+			if abs(c.u.dot(c.v)) > 1e-9 || abs(c.u.dot(c.w)) > 1e-9 || abs(c.v.dot(c.w)) > 1e-9 {
+				t.Errorf("basis %v, %v, %v is not orthogonal", c.u, c.v, c.w)
+			}
+			got := c.pose()
+			if got.lookAt.subtract(tt.pose.lookAt.normalize()).l2() > 1e-9 {
+				t.Errorf("looking at %v, want %v", got.lookAt, tt.pose.lookAt.normalize())
+			}
+			if abs(got.roll-tt.pose.roll) > 1e-9 {
+				t.Errorf("roll %g, want %g", got.roll, tt.pose.roll)
+			}
+		})
+	}
+}
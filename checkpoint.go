@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const checkpointMagic = "RTCK"
+
+// Fixed-size start of a checkpoint file, followed by the film's color and
+// weight sums per pixel as little-endian float64 x, y, z and weight, then
+// the sums of the passes' luminance and squared luminance per pixel and, if
+// the camera records them, a checkpointAOV per pixel.
+type checkpointHeader struct {
+	Magic        [4]byte
+	Version      uint32
+	Width        uint32
+	Height       uint32
+	AntiAliasing uint32
+	Passes       uint32     // Passes of samples accumulated in the film
+	Pose         [9]float64 // Camera pose the film was rendered from, as in keyframe.values
+	Settings     [32]byte   // Hash of the scene and the other settings samples depend on, see settingsHash
+	AOVs         uint32     // 1 if the AOVs of the last pass follow the variance sums
+}
+
+// AOVs of one pixel, so a resumed render that traces no more passes still
+// has them for -aovs and the denoiser.
+type checkpointAOV struct {
+	Albedo, Normal, Position [3]float64
+	Depth                    float64
+	MaterialID, ObjectID     int64
+}
+
+func (c *camera) checkpointHeader(w *world) (checkpointHeader, error) {
+	settings, err := c.settingsHash(w)
+	if err != nil {
+		return checkpointHeader{}, err
+	}
+	h := checkpointHeader{
+		Magic:        [4]byte([]byte(checkpointMagic)),
+		Version:      5,
+		Width:        uint32(c.imgWidth),
+		Height:       uint32(c.imgHeight),
+		AntiAliasing: uint32(c.antiAliasing),
+		Passes:       uint32(c.passes),
+		Pose:         c.pose().values(),
+		Settings:     settings,
+	}
+	if c.aovs != nil {
+		h.AOVs = 1
+	}
+	return h, nil
+}
+
+// Returns a SHA-256 hash of the encoded scene and the camera settings that
+// decide where samples land and what they see, besides the resolution,
+// sampling and pose the header holds on their own.
+func (c *camera) settingsHash(w *world) ([32]byte, error) {
+	h := sha256.New()
+	if err := (&scene{world: w}).encode(h); err != nil {
+		return [32]byte{}, err
+	}
+	fmt.Fprintf(h, "model %s\n", c.model.name())
+	if l, ok := c.model.(*realisticLens); ok {
+		fmt.Fprintf(h, "lens %v %g\n", l.prescription(), l.filmDiagonal)
+	}
+	fmt.Fprintf(h, "defocus %g tilt %g shift %g %g\n", c.defocusAngle, c.tilt, c.shiftHorizontal, c.shiftVertical)
+	fmt.Fprintf(h, "aperture %d %g %g\n", c.aperture.blades, c.aperture.rotation, c.aperture.squeeze)
+	if mask := c.aperture.mask; mask != nil {
+		fmt.Fprintf(h, "mask %v %d\n", mask.Rect, mask.Stride)
+		h.Write(mask.Pix)
+	}
+	fmt.Fprintf(h, "stereo %d %g %g\n", c.stereo, c.interpupillaryDistance, c.convergence)
+	fmt.Fprintf(h, "filter %#v\n", c.filter)
+	fmt.Fprintf(h, "maxDepth %d\n", c.maxDepth)
+	return [32]byte(h.Sum(nil)), nil
+}
+
+func (c *camera) encodeCheckpoint(w io.Writer, world *world) error {
+	header, err := c.checkpointHeader(world)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	var buf [32]byte
+	for i, col := range c.film.color {
+		binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(col.x))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(col.y))
+		binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(col.z))
+		binary.LittleEndian.PutUint64(buf[24:], math.Float64bits(c.film.weight[i]))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	for i, sum := range c.variance.sum {
+		binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(sum))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(c.variance.sumSquares[i]))
+		if _, err := bw.Write(buf[:16]); err != nil {
+			return err
+		}
+	}
+	if a := c.aovs; a != nil {
+		for i := range a.depth {
+			aov := checkpointAOV{
+				Albedo:     [3]float64{a.albedo[i].x, a.albedo[i].y, a.albedo[i].z},
+				Normal:     [3]float64{a.normal[i].x, a.normal[i].y, a.normal[i].z},
+				Position:   [3]float64{a.position[i].x, a.position[i].y, a.position[i].z},
+				Depth:      a.depth[i],
+				MaterialID: int64(a.materialID[i]),
+				ObjectID:   int64(a.objectID[i]),
+			}
+			if err := binary.Write(bw, binary.LittleEndian, aov); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Restores the film and its pass count from a checkpoint, which must have
+// been rendered of the same world at the camera's resolution, sampling, pose
+// and settings.
+func (c *camera) decodeCheckpoint(r io.Reader, world *world) error {
+	br := bufio.NewReader(r)
+	var h checkpointHeader
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	want, err := c.checkpointHeader(world)
+	if err != nil {
+		return err
+	}
+	switch {
+	case string(h.Magic[:]) != checkpointMagic || h.Version != want.Version:
+		return errors.New("not a checkpoint of this version")
+	case h.Width != want.Width || h.Height != want.Height:
+		return fmt.Errorf("checkpoint is %dx%d, not %dx%d", h.Width, h.Height, want.Width, want.Height)
+	case h.AntiAliasing != want.AntiAliasing:
+		return fmt.Errorf("checkpoint takes %d samples per pixel side, not %d", h.AntiAliasing, want.AntiAliasing)
+	case h.Pose != want.Pose:
+		return errors.New("checkpoint was rendered from another camera pose")
+	case h.Settings != want.Settings:
+		return errors.New("checkpoint was rendered of another scene or with other camera settings")
+	case want.AOVs == 1 && h.AOVs == 0:
+		return errors.New("checkpoint has no AOVs, which -aovs and -denoise need")
+	}
+
+	var buf [32]byte
+	for i := range c.film.color {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return err
+		}
+		c.film.color[i] = vec3{
+			math.Float64frombits(binary.LittleEndian.Uint64(buf[0:])),
+			math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+			math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+		}
+		c.film.weight[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[24:]))
+	}
+	c.variance = passVariance{
+		passes:     int(h.Passes),
+		sum:        make([]float64, len(c.film.color)),
+		sumSquares: make([]float64, len(c.film.color)),
+	}
+	for i := range c.variance.sum {
+		if _, err := io.ReadFull(br, buf[:16]); err != nil {
+			return err
+		}
+		c.variance.sum[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[0:]))
+		c.variance.sumSquares[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8:]))
+	}
+	if a := c.aovs; a != nil {
+		for i := range a.depth {
+			var aov checkpointAOV
+			if err := binary.Read(br, binary.LittleEndian, &aov); err != nil {
+				return err
+			}
+			a.albedo[i] = vec3{aov.Albedo[0], aov.Albedo[1], aov.Albedo[2]}
+			a.normal[i] = vec3{aov.Normal[0], aov.Normal[1], aov.Normal[2]}
+			a.position[i] = vec3{aov.Position[0], aov.Position[1], aov.Position[2]}
+			a.depth[i] = aov.Depth
+			a.materialID[i], a.objectID[i] = int(aov.MaterialID), int(aov.ObjectID)
+		}
+	}
+	c.passes = int(h.Passes)
+	return nil
+}
+
+// Writes the film to a temporary file next to path and renames it over path,
+// so a crash while saving keeps the previous checkpoint intact.
+func (c *camera) saveCheckpoint(path string, world *world) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := c.encodeCheckpoint(file, world); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (c *camera) loadCheckpoint(path string, world *world) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := c.decodeCheckpoint(file, world); err != nil {
+		c.film.clear()
+		c.variance = passVariance{}
+		c.passes = 0
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkpointTestWorld() *world {
+	return &world{
+		objects: []hittable{
+			sphere{center: vec3{0, 0, -1.2}, radius: 0.5, mat: lambertian{albedo: vec3{0.1, 0.2, 0.5}}},
+			sphere{center: vec3{0, -100.5, -1}, radius: 100, mat: lambertian{albedo: vec3{0.8, 0.8, 0}}},
+		},
+	}
+}
+
+func checkpointTestCamera(t *testing.T, lens, aovs bool, defocusAngle float64) *camera {
+	params := cameraParams{
+		imgWidth:      16,
+		aspectRatio:   16.0 / 9.0,
+		verticalFov:   60,
+		lookFrom:      vec3{0, 0.3, 1},
+		lookAt:        vec3{0, 0, -1},
+		focalDistance: 2,
+		defocusAngle:  defocusAngle,
+		antiAliasing:  1,
+		maxDepth:      4,
+		aovs:          aovs,
+	}
+	if lens {
+		l, err := parseLens(strings.NewReader(singletLens), 35, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params.model = l
+	}
+	return cameraInit(params)
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		lens       bool    // Whether both cameras use the realistic lens
+		saveAOVs   bool    // Whether the saving camera records AOVs
+		resumeAOVs bool    // Whether the resuming camera records AOVs
+		resume     float64 // Defocus angle of the resuming camera
+		wantErr    string  // Part of the expected error, empty if the film should resume
+	}{
+		{name: "pinhole"},
+		{name: "lens", lens: true},
+		{name: "aovs", saveAOVs: true, resumeAOVs: true},
+		{name: "aovs dropped", saveAOVs: true},
+		{name: "aovs missing", resumeAOVs: true, wantErr: "no AOVs"},
+		{name: "other settings", resume: 2, wantErr: "camera settings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := checkpointTestWorld()
+			saved := checkpointTestCamera(t, tt.lens, tt.saveAOVs, 0)
+			saved.render(w)
+			var buf bytes.Buffer
+			if err := saved.encodeCheckpoint(&buf, w); err != nil {
+				t.Fatal(err)
+			}
+
+			resumed := checkpointTestCamera(t, tt.lens, tt.resumeAOVs, tt.resume)
+			err := resumed.decodeCheckpoint(&buf, w)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed.passes != saved.passes {
+				t.Errorf("resumed %d passes, want %d", resumed.passes, saved.passes)
+			}
+			for i := range saved.film.color {
+				if resumed.film.color[i] != saved.film.color[i] || resumed.film.weight[i] != saved.film.weight[i] {
+					t.Fatalf("pixel %d resumed as %v, %v, want %v, %v", i, resumed.film.color[i], resumed.film.weight[i], saved.film.color[i], saved.film.weight[i])
+				}
+			}
+			if tt.resumeAOVs && !reflect.DeepEqual(resumed.aovs, saved.aovs) {
+				t.Error("resumed AOVs differ from the saved ones")
+			}
+		})
+	}
+}
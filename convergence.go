@@ -0,0 +1,46 @@
+package main
+
+import "math"
+
+// Per-pixel luminance sums over the images of individual passes, from which
+// the remaining noise of the accumulated image is estimated.
+type passVariance struct {
+	passes     int       // Passes added
+	sum        []float64 // Sum of each pass's pixel luminance
+	sumSquares []float64 // Sum of the squares of each pass's pixel luminance
+}
+
+// Adds the image of a single pass, resolved from its film.
+func (v *passVariance) add(f *film) {
+	if v.passes == 0 {
+		v.sum = make([]float64, f.width*f.height)
+		v.sumSquares = make([]float64, f.width*f.height)
+	}
+	for y := range f.height {
+		for x := range f.width {
+			col := f.resolve(f.x0+x, f.y0+y)
+			l := 0.2126*col.x + 0.7152*col.y + 0.0722*col.z
+			v.sum[y*f.width+x] += l
+			v.sumSquares[y*f.width+x] += l * l
+		}
+	}
+	v.passes++
+}
+
+// Returns the standard error of the accumulated image's pixels relative to
+// their luminance, averaged over the image, or infinity before two passes.
+// Dark pixels count as if they had a luminance of 0.05 so that noise hardly
+// visible in shadows does not dominate the estimate.
+func (v *passVariance) estimate() float64 {
+	if v.passes < 2 {
+		return math.Inf(1)
+	}
+	n := float64(v.passes)
+	total := 0.0
+	for i, sum := range v.sum {
+		mean := sum / n
+		variance := max(0, v.sumSquares[i]/n-mean*mean) * n / (n - 1)
+		total += math.Sqrt(variance/n) / max(mean, 0.05)
+	}
+	return total / float64(len(v.sum))
+}
@@ -0,0 +1,107 @@
+package main
+
+import "math"
+
+// Edge-avoiding à-trous wavelet filter (Dammertz et al. 2010). Each iteration
+// applies a 5x5 B3-spline kernel with holes spaced 2^i pixels apart, weighting
+// every tap by how similar its color, albedo, normal and depth are to the
+// center pixel so that blurring stops at geometric and texture edges.
+type denoiser struct {
+	iterations  int     // Number of wavelet passes, each doubling the kernel footprint
+	colorSigma  float64 // Tolerance to illumination differences, halved every pass
+	albedoSigma float64 // Tolerance to base color differences
+	normalSigma float64 // Tolerance to shading normal differences
+	depthSigma  float64 // Tolerance to depth differences, relative to the center depth
+}
+
+var defaultDenoiser = denoiser{
+	iterations:  5,
+	colorSigma:  1.0,
+	albedoSigma: 0.1,
+	normalSigma: 0.2,
+	depthSigma:  0.05,
+}
+
+var aTrousKernel = [5]float64{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+func (d denoiser) apply(img []vec3, imgWidth, imgHeight int, aovs *aovBuffers) []vec3 {
+	// Filter illumination rather than final color so that texture detail
+	// carried by the albedo is not blurred, then modulate it back.
+	irradiance := make([]vec3, len(img))
+	for i, col := range img {
+		irradiance[i] = demodulate(col, aovs.albedo[i])
+	}
+
+	filtered := make([]vec3, len(img))
+	colorSigma := d.colorSigma
+	for iteration := range d.iterations {
+		step := 1 << iteration
+		for y := range imgHeight {
+			for x := range imgWidth {
+				filtered[y*imgWidth+x] = d.filterPixel(irradiance, x, y, step, colorSigma, imgWidth, imgHeight, aovs)
+			}
+		}
+		irradiance, filtered = filtered, irradiance
+		colorSigma /= 2
+	}
+
+	out := make([]vec3, len(img))
+	for i := range out {
+		out[i] = modulate(irradiance[i], aovs.albedo[i])
+	}
+	return out
+}
+
+func (d denoiser) filterPixel(img []vec3, x, y, step int, colorSigma float64, imgWidth, imgHeight int, aovs *aovBuffers) vec3 {
+	idx := y*imgWidth + x
+	sum := vec3{0, 0, 0}
+	weightSum := 0.0
+
+	for j := -2; j <= 2; j++ {
+		ty := y + j*step
+		if ty < 0 || ty >= imgHeight {
+			continue
+		}
+		for i := -2; i <= 2; i++ {
+			tx := x + i*step
+			if tx < 0 || tx >= imgWidth {
+				continue
+			}
+			tIdx := ty*imgWidth + tx
+
+			w := aTrousKernel[i+2] * aTrousKernel[j+2]
+			w *= math.Exp(-img[tIdx].subtract(img[idx]).l2Squared() / (colorSigma * colorSigma))
+			w *= math.Exp(-aovs.albedo[tIdx].subtract(aovs.albedo[idx]).l2Squared() / (d.albedoSigma * d.albedoSigma))
+			w *= math.Exp(-aovs.normal[tIdx].subtract(aovs.normal[idx]).l2Squared() / (d.normalSigma * d.normalSigma))
+			w *= d.depthWeight(aovs.depth[idx], aovs.depth[tIdx])
+
+			sum = sum.add(img[tIdx].scale(w))
+			weightSum += w
+		}
+	}
+
+	if weightSum <= 0 {
+		return img[idx]
+	}
+	return sum.divide(weightSum)
+}
+
+func (d denoiser) depthWeight(center, tap float64) float64 {
+	centerMissed, tapMissed := math.IsInf(center, 1), math.IsInf(tap, 1)
+	if centerMissed || tapMissed {
+		if centerMissed == tapMissed {
+			return 1
+		}
+		return 0
+	}
+	diff := (tap - center) / (d.depthSigma * max(center, 1e-3))
+	return math.Exp(-diff * diff)
+}
+
+func demodulate(col, albedo vec3) vec3 {
+	return vec3{col.x / max(albedo.x, 1e-2), col.y / max(albedo.y, 1e-2), col.z / max(albedo.z, 1e-2)}
+}
+
+func modulate(irradiance, albedo vec3) vec3 {
+	return vec3{irradiance.x * max(albedo.x, 1e-2), irradiance.y * max(albedo.y, 1e-2), irradiance.z * max(albedo.z, 1e-2)}
+}
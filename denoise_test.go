@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func denoiseTestRender(w *world, antiAliasing int, denoise bool) []vec3 {
+	c := cameraInit(cameraParams{
+		imgWidth:      64,
+		aspectRatio:   16.0 / 9.0,
+		verticalFov:   60,
+		lookFrom:      vec3{0, 0.3, 1},
+		lookAt:        vec3{0, 0, -1},
+		focalDistance: 1,
+		antiAliasing:  antiAliasing,
+		maxDepth:      10,
+		denoise:       denoise,
+	})
+	c.render(w)
+	return c.image
+}
+
+func meanSquaredError(img, reference []vec3) float64 {
+	total := 0.0
+	for i, col := range img {
+		total += col.subtract(reference[i]).l2Squared() / 3
+	}
+	return total / float64(len(img))
+}
+
+func TestDenoiseReducesError(t *testing.T) {
+	w := &world{
+		objects: []hittable{
+			sphere{center: vec3{0, 0, -1.2}, radius: 0.5, mat: lambertian{albedo: vec3{0.1, 0.2, 0.5}}},
+			sphere{center: vec3{1, 0, -1}, radius: 0.5, mat: metal{albedo: vec3{0.8, 0.6, 0.2}, fuzz: 0.2}},
+			sphere{center: vec3{0, -100.5, -1}, radius: 100, mat: lambertian{albedo: vec3{0.8, 0.8, 0}}},
+		},
+	}
+	reference := denoiseTestRender(w, 16, false)
+	noisy := meanSquaredError(denoiseTestRender(w, 1, false), reference)
+	denoised := meanSquaredError(denoiseTestRender(w, 1, true), reference)
+	t.Logf("MSE against %d spp reference: %.4f noisy, %.4f denoised", 16*16, noisy, denoised)
+	// Denoising roughly halves the error, part of what remains is the
+	// reference's antialiasing along edges
+	if denoised > 0.75*noisy {
+		t.Errorf("denoised MSE %.4f is not clearly below the noisy MSE %.4f", denoised, noisy)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"net"
+	"net/rpc"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types sent between the coordinator and workers are exported as net/rpc
+// requires.
+
+// What a worker process needs to render tiles like the coordinator would.
+type DistributedJob struct {
+	Args  []string // Command-line flags the coordinator was started with
+	Scene string   // Scene file contents including the camera pose
+}
+
+type TileAssignment struct {
+	Done       bool            // Whether the render is over and the worker should exit
+	Generation int             // Tile set the tile belongs to, results of abandoned sets are dropped
+	Pass       int             // Passes accumulated before this one, which decides how samples are placed
+	Tile       image.Rectangle // Pixels to render
+}
+
+type TileResult struct {
+	Generation    int
+	Tile          image.Rectangle
+	Bounds        image.Rectangle // Pixels covered by the film, the tile and its filter margin
+	Color, Weight []float64       // Film sums per pixel, three values per pixel for color
+	Counts        [3]int64        // Primary and secondary rays and intersection tests
+}
+
+// Hands tiles out to worker processes connected over TCP and merges the
+// films they send back into the camera's. Tiles held by a worker that
+// disconnects are handed to the next worker asking for one.
+type coordinator struct {
+	job        DistributedJob
+	listener   net.Listener
+	mu         sync.Mutex
+	cond       *sync.Cond        // Signalled when tiles are queued or done, a worker leaves or the render ends
+	camera     *camera           // Camera of the tile set being rendered
+	generation int               // Tile set being rendered
+	pending    []image.Rectangle // Tiles of the set not handed out yet
+	remaining  int               // Tiles of the set not merged yet
+	done       bool              // Whether the render is over
+	workers    sync.WaitGroup    // Connected workers
+}
+
+// Starts accepting workers on addr, which will render the given scene with
+// the flags this process was started with, except the scene file, addresses
+// and worker count, which each worker picks for itself.
+func listenCoordinator(addr string, sc *scene) (*coordinator, error) {
+	var sb strings.Builder
+	if err := sc.encode(&sb); err != nil {
+		return nil, err
+	}
+	job := DistributedJob{Scene: sb.String()}
+	flag.Visit(func(f *flag.Flag) {
+		if !slices.Contains([]string{"scene", "listen", "worker", "workers"}, f.Name) {
+			job.Args = append(job.Args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	co := &coordinator{job: job, listener: listener}
+	co.cond = sync.NewCond(&co.mu)
+	fmt.Fprintf(os.Stderr, "waiting for workers on %s\n", listener.Addr())
+	go co.accept()
+	return co, nil
+}
+
+func (co *coordinator) accept() {
+	for {
+		conn, err := co.listener.Accept()
+		if err != nil {
+			return
+		}
+		co.workers.Add(1)
+		go func() {
+			defer co.workers.Done()
+			w := &coordinatorConn{co: co}
+			server := rpc.NewServer()
+			if err := server.RegisterName("Coordinator", w); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				conn.Close()
+				return
+			}
+			server.ServeConn(&hangupConn{Conn: conn, hangup: w.leave})
+		}()
+	}
+}
+
+// Connection calling hangup as soon as reading from it fails, as the RPC
+// server only returns once the calls still waiting for tiles return.
+type hangupConn struct {
+	net.Conn
+	hangup func()
+	once   sync.Once
+}
+
+func (c *hangupConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.once.Do(c.hangup)
+	}
+	return n, err
+}
+
+// Renders tiles on the connected workers, waiting for workers to connect if
+// there are none. Returns early if ctx is cancelled, dropping the tiles still
+// being rendered.
+func (co *coordinator) renderTiles(ctx context.Context, c *camera, tiles []image.Rectangle) {
+	stop := context.AfterFunc(ctx, func() {
+		co.mu.Lock()
+		defer co.mu.Unlock()
+		co.cond.Broadcast()
+	})
+	defer stop()
+
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.camera = c
+	co.generation++
+	co.pending = slices.Clone(tiles)
+	co.remaining = len(tiles)
+	co.cond.Broadcast()
+	for co.remaining > 0 && ctx.Err() == nil {
+		co.cond.Wait()
+	}
+	co.generation++
+	co.pending = nil
+}
+
+// Tells the workers the render is over and stops accepting new ones, giving
+// connected workers a moment to hear about it and disconnect.
+func (co *coordinator) close() {
+	co.mu.Lock()
+	co.done = true
+	co.cond.Broadcast()
+	co.mu.Unlock()
+	co.listener.Close()
+
+	left := make(chan struct{})
+	go func() {
+		co.workers.Wait()
+		close(left)
+	}()
+	select {
+	case <-left:
+	case <-time.After(time.Second):
+	}
+}
+
+// Methods called over RPC by one connected worker.
+type coordinatorConn struct {
+	co   *coordinator
+	held []TileAssignment // Tiles handed to the worker and not returned yet
+	gone bool             // Whether the worker disconnected
+}
+
+func (w *coordinatorConn) Job(_ struct{}, job *DistributedJob) error {
+	*job = w.co.job
+	return nil
+}
+
+// Waits for a tile to render and hands it to the worker.
+func (w *coordinatorConn) Next(_ struct{}, a *TileAssignment) error {
+	co := w.co
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	for len(co.pending) == 0 && !co.done && !w.gone {
+		co.cond.Wait()
+	}
+	if w.gone {
+		return errors.New("worker disconnected")
+	}
+	if co.done {
+		a.Done = true
+		return nil
+	}
+	*a = TileAssignment{Generation: co.generation, Pass: co.camera.passes, Tile: co.pending[0]}
+	co.pending = co.pending[1:]
+	w.held = append(w.held, *a)
+	return nil
+}
+
+func (w *coordinatorConn) Result(r TileResult, _ *struct{}) error {
+	co := w.co
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	i := slices.IndexFunc(w.held, func(a TileAssignment) bool { return a.Generation == r.Generation && a.Tile == r.Tile })
+	if i < 0 {
+		return errors.New("result for a tile that was not assigned")
+	}
+	w.held = slices.Delete(w.held, i, i+1)
+	if r.Generation != co.generation {
+		return nil
+	}
+
+	f := filmInit(r.Bounds.Min.X, r.Bounds.Min.Y, r.Bounds.Dx(), r.Bounds.Dy())
+	if len(r.Color) != 3*len(f.color) || len(r.Weight) != len(f.weight) {
+		return errors.New("result film does not match its bounds")
+	}
+	for i := range f.color {
+		f.color[i] = vec3{r.Color[3*i], r.Color[3*i+1], r.Color[3*i+2]}
+	}
+	copy(f.weight, r.Weight)
+	co.camera.mergeTile(f, rayCounts{r.Counts[0], r.Counts[1], r.Counts[2]})
+	co.remaining--
+	co.cond.Broadcast()
+	return nil
+}
+
+// Queues the tiles the worker held again for others to render.
+func (w *coordinatorConn) leave() {
+	co := w.co
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	for _, a := range w.held {
+		if a.Generation == co.generation {
+			co.pending = append(co.pending, a.Tile)
+		}
+	}
+	w.held = nil
+	w.gone = true
+	co.cond.Broadcast()
+}
+
+// Connects to the coordinator at addr and fetches the job to render.
+func dialCoordinator(addr string) (*rpc.Client, *DistributedJob, error) {
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	job := &DistributedJob{}
+	if err := client.Call("Coordinator.Job", struct{}{}, job); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, job, nil
+}
+
+// Renders tiles handed out by the coordinator on the camera's workers until
+// it reports the render is over.
+func runWorker(client *rpc.Client, c *camera, w *world) error {
+	defer client.Close()
+	if model, ok := c.model.(preparedCameraModel); ok {
+		model.prepare(c)
+	}
+	errs := make([]error, c.workers)
+	var wg sync.WaitGroup
+	for i := range c.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = renderAssignments(client, c, w)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func renderAssignments(client *rpc.Client, c *camera, w *world) error {
+	for {
+		var a TileAssignment
+		if err := client.Call("Coordinator.Next", struct{}{}, &a); err != nil {
+			return err
+		}
+		if a.Done {
+			return nil
+		}
+
+		f, counts := c.traceTile(context.Background(), a.Tile, a.Pass, w)
+		r := TileResult{
+			Generation: a.Generation,
+			Tile:       a.Tile,
+			Bounds:     image.Rect(f.x0, f.y0, f.x0+f.width, f.y0+f.height),
+			Color:      make([]float64, 0, 3*len(f.color)),
+			Weight:     f.weight,
+			Counts:     [3]int64{counts.primaryRays, counts.secondaryRays, counts.intersectionTests},
+		}
+		for _, col := range f.color {
+			r.Color = append(r.Color, col.x, col.y, col.z)
+		}
+		if err := client.Call("Coordinator.Result", r, &struct{}{}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Mirror-like spheres under the sky, so every sample is deterministic and
+// films rendered in different processes can be compared exactly.
+func distributedTestWorld() *world {
+	return &world{
+		objects: []hittable{
+			sphere{center: vec3{0, 0, -1.2}, radius: 0.5, mat: metal{albedo: vec3{0.8, 0.6, 0.2}}},
+			sphere{center: vec3{0, -100.5, -1}, radius: 100, mat: metal{albedo: vec3{0.5, 0.5, 0.5}}},
+		},
+	}
+}
+
+func distributedTestCamera() *camera {
+	return cameraInit(cameraParams{
+		imgWidth:      48,
+		aspectRatio:   16.0 / 9.0,
+		verticalFov:   60,
+		lookFrom:      vec3{0, 0.3, 1},
+		lookAt:        vec3{0, 0, -1},
+		focalDistance: 1,
+		antiAliasing:  2,
+		maxDepth:      4,
+		workers:       2,
+	})
+}
+
+// Renders on two workers connected over TCP, one of which disconnects while
+// holding a tile, and compares the merged film with a local render.
+func TestDistributedRenderMatchesLocal(t *testing.T) {
+	w := distributedTestWorld()
+	local := distributedTestCamera()
+	local.render(w)
+
+	pose := local.pose()
+	co, err := listenCoordinator("127.0.0.1:0", &scene{world: w, camera: &pose})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := co.listener.Addr().String()
+
+	leaver, _, err := dialCoordinator(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	distributed := distributedTestCamera()
+	distributed.coordinator = co
+	rendered := make(chan struct{})
+	go func() {
+		distributed.render(w)
+		close(rendered)
+	}()
+
+	var held TileAssignment
+	if err := leaver.Call("Coordinator.Next", struct{}{}, &held); err != nil {
+		t.Fatal(err)
+	}
+	leaver.Close()
+
+	client, job, err := dialCoordinator(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := parseScene(strings.NewReader(job.Scene))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workerErr := make(chan error)
+	go func() {
+		workerErr <- runWorker(client, distributedTestCamera(), sc.world)
+	}()
+
+	<-rendered
+	co.close()
+	if err := <-workerErr; err != nil {
+		t.Errorf("worker did not shut down cleanly: %v", err)
+	}
+
+	for i := range local.film.color {
+		if local.film.weight[i] != distributed.film.weight[i] || local.film.color[i].subtract(distributed.film.color[i]).l2() > 1e-12 {
+			t.Fatalf("pixel %d is %v, %v, want %v, %v", i, distributed.film.color[i], distributed.film.weight[i], local.film.color[i], local.film.weight[i])
+		}
+	}
+	if distributed.stats.rayCounts != local.stats.rayCounts {
+		t.Errorf("ray counts are %+v, want %+v", distributed.stats.rayCounts, local.stats.rayCounts)
+	}
+	if distributed.tilesDone != distributed.stats.tiles {
+		t.Errorf("merged %d of %d tiles", distributed.tilesDone, distributed.stats.tiles)
+	}
+}
+
+func TestCoordinatorResult(t *testing.T) {
+	tile := image.Rect(0, 0, 2, 1)
+	result := func(generation int) TileResult {
+		return TileResult{
+			Generation: generation,
+			Tile:       tile,
+			Bounds:     tile,
+			Color:      []float64{1, 1, 1, 1, 1, 1},
+			Weight:     []float64{1, 1},
+		}
+	}
+	tests := []struct {
+		name          string
+		held          []TileAssignment
+		result        TileResult
+		wantErr       bool
+		wantRemaining int // Tiles of the current set left to merge afterwards
+	}{
+		{name: "current", held: []TileAssignment{{Generation: 2, Tile: tile}}, result: result(2), wantRemaining: 0},
+		{name: "stale generation", held: []TileAssignment{{Generation: 1, Tile: tile}}, result: result(1), wantRemaining: 1},
+		{name: "not assigned", result: result(2), wantErr: true, wantRemaining: 1},
+		{name: "mismatched film", held: []TileAssignment{{Generation: 2, Tile: tile}}, result: TileResult{Generation: 2, Tile: tile, Bounds: tile}, wantErr: true, wantRemaining: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cameraInit(cameraParams{imgWidth: 2, aspectRatio: 2, verticalFov: 60, lookAt: vec3{0, 0, -1}, focalDistance: 1, antiAliasing: 1})
+			co := &coordinator{camera: c, generation: 2, remaining: 1}
+			co.cond = sync.NewCond(&co.mu)
+			conn := &coordinatorConn{co: co, held: tt.held}
+
+			err := conn.Result(tt.result, &struct{}{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if co.remaining != tt.wantRemaining {
+				t.Errorf("%d tiles remaining, want %d", co.remaining, tt.wantRemaining)
+			}
+			merged := c.film.weight[0] != 0
+			if merged != (tt.wantRemaining == 0) {
+				t.Errorf("film merged is %v, want %v", merged, tt.wantRemaining == 0)
+			}
+		})
+	}
+}
+
+// Checks that the tiles a leaving worker held go back to the queue, except
+// those of abandoned tile sets.
+func TestCoordinatorLeave(t *testing.T) {
+	co := &coordinator{generation: 2}
+	co.cond = sync.NewCond(&co.mu)
+	current, stale := image.Rect(0, 0, 8, 8), image.Rect(8, 0, 16, 8)
+	conn := &coordinatorConn{co: co, held: []TileAssignment{{Generation: 2, Tile: current}, {Generation: 1, Tile: stale}}}
+	conn.leave()
+	if len(co.pending) != 1 || co.pending[0] != current {
+		t.Errorf("pending tiles are %v, want %v", co.pending, []image.Rectangle{current})
+	}
+	if err := conn.Next(struct{}{}, &TileAssignment{}); err == nil {
+		t.Error("a worker that left was handed a tile")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+type editor struct {
+	path     string       // Scene file the edited world is saved to
+	selected int          // Index of the selected object in the world, -1 if none
+	editing  bool         // Whether an edit is in progress, so it is undone as a whole
+	undo     [][]hittable // World objects before each edit, most recent last
+	redo     [][]hittable // World objects before each undone edit, most recent last
+	drag     mouse        // Cursor position while dragging the selection
+	param    int          // Index of the material parameter being adjusted
+	status   string       // Outcome of the last save, shown on screen
+}
+
+// While the cursor is free, a left click selects the object under it and
+// dragging moves it across the view. The nudge actions, arrows and page up
+// and down by default, move the selection, plus and minus scale it and comma
+// and period rotate it around the vertical axis. Its material is edited as
+// described in updateMaterial. Ctrl+Z undoes, Ctrl+Y redoes and F5 saves the
+// scene.
+func (g *game) updateEdit() {
+	e := &g.edit
+	if g.input.justPressed(undo) {
+		e.step(g.world, &e.undo, &e.redo)
+	}
+	if g.input.justPressed(redo) {
+		e.step(g.world, &e.redo, &e.undo)
+	}
+	if g.input.justPressed(saveEdits) {
+		pose := g.camera.pose()
+		e.status = fmt.Sprintf("SAVED SCENE TO %s", e.path)
+		if err := saveScene(&scene{world: g.world, camera: &pose}, e.path); err != nil {
+			e.status = err.Error()
+		}
+		g.watch.sync(pose)
+	}
+
+	mx, my := ebiten.CursorPosition()
+	free := ebiten.CursorMode() != ebiten.CursorModeCaptured
+	if free && inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && g.width > 0 && g.height > 0 {
+		eye, s, t := g.camera.viewCoordinates(float64(mx)/float64(g.width)*float64(g.camera.imgWidth), float64(my)/float64(g.height)*float64(g.camera.imgHeight))
+		e.selected = -1
+		if hr, ok := g.camera.pick(g.world, eye, s, t); ok {
+			e.selected, e.param = hr.objectID, 0
+		}
+		e.drag = mouse{mx, my}
+	}
+	if e.selected < 0 {
+		e.editing = false
+		return
+	}
+	if g.camera.aovs == nil {
+		// The render records the object under each pixel, which outlines the selection
+		g.camera.aovs = aovBuffersInit(g.camera.imgWidth * g.camera.imgHeight)
+	}
+
+	edited := g.world.objects[e.selected]
+	if object, ok := edited.(transformable); ok {
+		edited = g.transformSelection(object)
+	}
+	if object, ok := edited.(resurfaceable); ok {
+		if mat, ok := g.updateMaterial(edited.surface()); ok {
+			edited = object.withSurface(mat)
+		}
+	}
+
+	if edited == g.world.objects[e.selected] {
+		if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+			e.editing = false
+		}
+		return
+	}
+	if !e.editing {
+		e.undo = append(e.undo, slices.Clone(g.world.objects))
+		e.redo = nil
+		e.editing = true
+	}
+	g.world.objects[e.selected] = edited
+}
+
+func (g *game) transformSelection(object transformable) hittable {
+	e := &g.edit
+	mx, my := ebiten.CursorPosition()
+	distance := max(0.01, g.camera.center.subtract(object.pivot()).dot(g.camera.w))
+	step := 0.02 * distance
+	offset := vec3{0, 0, 0}
+	if ebiten.CursorMode() != ebiten.CursorModeCaptured && ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) && g.height > 0 {
+		unitsPerPixel := g.camera.viewportHeight / g.camera.focalDistance * distance / float64(g.height)
+		offset = g.camera.u.scale(float64(mx-e.drag.x) * unitsPerPixel).
+			subtract(g.camera.v.scale(float64(my-e.drag.y) * unitsPerPixel))
+		e.drag = mouse{mx, my}
+	}
+	nudges := map[action]vec3{
+		nudgeLeft:   g.camera.u.scale(-step),
+		nudgeRight:  g.camera.u.scale(step),
+		nudgeUp:     g.camera.upDir.scale(step),
+		nudgeDown:   g.camera.upDir.scale(-step),
+		nudgeAway:   g.camera.w.scale(-step),
+		nudgeCloser: g.camera.w.scale(step),
+	}
+	for a, v := range nudges {
+		if g.input.pressed(a) {
+			offset = offset.add(v)
+		}
+	}
+
+	scale, angle := 1.0, 0.0
+	if g.input.pressed(growSelection) {
+		scale *= 1.02
+	}
+	if g.input.pressed(shrinkSelection) {
+		scale /= 1.02
+	}
+	if g.input.pressed(turnSelectionLeft) {
+		angle -= deg2rad(2)
+	}
+	if g.input.pressed(turnSelectionRight) {
+		angle += deg2rad(2)
+	}
+
+	if offset == (vec3{0, 0, 0}) && scale == 1 && angle == 0 {
+		return object
+	}
+	return object.translated(offset).scaled(scale).rotated(g.camera.upDir, angle)
+}
+
+// M switches the material kind, 1 to 4 pick the parameter to adjust and
+// semicolon and apostrophe, or the mouse wheel while holding Alt, decrease
+// and increase it, unless rebound. Returns false if the material is
+// unchanged.
+func (g *game) updateMaterial(mat material) (material, bool) {
+	e := &g.edit
+	if g.input.justPressed(cycleMaterial) {
+		i := slices.Index(materialNames, mat.name())
+		e.param = 0
+		return convertMaterial(mat, materialNames[(i+1)%len(materialNames)]), true
+	}
+
+	params := mat.params()
+	for i, a := range []action{selectParam1, selectParam2, selectParam3, selectParam4} {
+		if g.input.justPressed(a) && i < len(params) {
+			e.param = i
+		}
+	}
+	e.param = min(e.param, len(params)-1)
+	param := params[e.param]
+
+	change := 0.0
+	if g.input.pressed(decreaseParam) {
+		change -= 0.01
+	}
+	if g.input.pressed(increaseParam) {
+		change += 0.01
+	}
+	if _, wy := ebiten.Wheel(); g.input.pressed(wheelAdjustsParam) {
+		change += 0.05 * wy
+	}
+	value := param.valid.clamp(param.value + change*(param.valid.max-param.valid.min))
+	if value == param.value {
+		return mat, false
+	}
+	return mat.withParam(e.param, value), true
+}
+
+// Restores the most recent world objects in from, saving the current ones
+// in to.
+func (e *editor) step(w *world, from, to *[][]hittable) {
+	if len(*from) == 0 {
+		return
+	}
+	*to = append(*to, w.objects)
+	w.objects = (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	e.editing = false
+	if e.selected >= len(w.objects) {
+		e.selected = -1
+	}
+}
+
+// Returns the pixels with the selected object tinted and outlined, finding it
+// through the object IDs the render recorded.
+func (g *game) highlightSelection(pixels []byte) []byte {
+	e := &g.edit
+	c := g.camera
+	if e.selected < 0 || c.aovs == nil {
+		return pixels
+	}
+
+	mask := make([]bool, c.imgWidth*c.imgHeight)
+	for i, id := range c.aovs.objectID {
+		mask[i] = id == e.selected
+	}
+
+	highlighted := slices.Clone(pixels)
+	highlight := [3]float64{255, 160, 0}
+	for y := range c.imgHeight {
+		for x := range c.imgWidth {
+			i := y*c.imgWidth + x
+			if !mask[i] {
+				continue
+			}
+			edge := x == 0 || y == 0 || x == c.imgWidth-1 || y == c.imgHeight-1 ||
+				!mask[i-1] || !mask[i+1] || !mask[i-c.imgWidth] || !mask[i+c.imgWidth]
+			blend := 0.3
+			if edge {
+				blend = 1
+			}
+			for ch := range 3 {
+				value := (1-blend)*float64(pixels[4*i+ch]) + blend*highlight[ch]
+				highlighted[4*i+ch] = uint8(math.Round(value))
+			}
+		}
+	}
+	return highlighted
+}
+
+// Lists the selected object's material parameters, marking the one being
+// adjusted.
+func (g *game) materialOverlay() string {
+	mat := g.world.objects[g.edit.selected].surface()
+	var b strings.Builder
+	fmt.Fprintf(&b, "MATERIAL: %s\n", strings.ToUpper(mat.name()))
+	for i, param := range mat.params() {
+		marker := " "
+		if i == g.edit.param {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %d %-16s %.3f\n", marker, i+1, strings.ToUpper(param.name), param.value)
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+type imageChannel struct {
+	name string    // Channel name, dot-separated into layer and component for EXR
+	data []float32 // One value per pixel, rows top to bottom
+}
+
+type exrCompression byte
+
+const (
+	exrNone exrCompression = 0 // Uncompressed scanlines
+	exrZips exrCompression = 2 // Zlib, one scanline per chunk
+	exrZip  exrCompression = 3 // Zlib, sixteen scanlines per chunk
+)
+
+var exrCompressions = []exrCompression{exrNone, exrZips, exrZip}
+
+func (c exrCompression) name() string {
+	switch c {
+	case exrZips:
+		return "zips"
+	case exrZip:
+		return "zip"
+	}
+	return "none"
+}
+
+func exrCompressionByName(name string) (exrCompression, bool) {
+	for _, c := range exrCompressions {
+		if c.name() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+func vec3Channels(prefix string, data []vec3, x, y, z string) []imageChannel {
+	channels := []imageChannel{
+		{name: prefix + x, data: make([]float32, len(data))},
+		{name: prefix + y, data: make([]float32, len(data))},
+		{name: prefix + z, data: make([]float32, len(data))},
+	}
+	for i, v := range data {
+		channels[0].data[i] = float32(v.x)
+		channels[1].data[i] = float32(v.y)
+		channels[2].data[i] = float32(v.z)
+	}
+	return channels
+}
+
+func (c exrCompression) linesPerChunk() int {
+	if c == exrZip {
+		return 16
+	}
+	return 1
+}
+
+// Writes a single-part scanline OpenEXR image with 32-bit float channels.
+func encodeExr(w io.Writer, channels []imageChannel, imgWidth, imgHeight int, compression exrCompression) error {
+	channels = slices.Clone(channels)
+	slices.SortFunc(channels, func(a, b imageChannel) int { return strings.Compare(a.name, b.name) })
+
+	var header bytes.Buffer
+	le := binary.LittleEndian
+	header.Write([]byte{0x76, 0x2f, 0x31, 0x01})
+	header.Write(le.AppendUint32(nil, 2))
+
+	var chlist bytes.Buffer
+	for _, ch := range channels {
+		chlist.WriteString(ch.name)
+		chlist.WriteByte(0)
+		chlist.Write(le.AppendUint32(nil, 2)) // FLOAT
+		chlist.Write([]byte{0, 0, 0, 0})      // pLinear and reserved
+		chlist.Write(le.AppendUint32(nil, 1))
+		chlist.Write(le.AppendUint32(nil, 1))
+	}
+	chlist.WriteByte(0)
+	box := le.AppendUint32(nil, 0)
+	box = le.AppendUint32(box, 0)
+	box = le.AppendUint32(box, uint32(imgWidth-1))
+	box = le.AppendUint32(box, uint32(imgHeight-1))
+
+	writeExrAttribute(&header, "channels", "chlist", chlist.Bytes())
+	writeExrAttribute(&header, "compression", "compression", []byte{byte(compression)})
+	writeExrAttribute(&header, "dataWindow", "box2i", box)
+	writeExrAttribute(&header, "displayWindow", "box2i", box)
+	writeExrAttribute(&header, "lineOrder", "lineOrder", []byte{0})
+	writeExrAttribute(&header, "pixelAspectRatio", "float", le.AppendUint32(nil, math.Float32bits(1)))
+	writeExrAttribute(&header, "screenWindowCenter", "v2f", make([]byte, 8))
+	writeExrAttribute(&header, "screenWindowWidth", "float", le.AppendUint32(nil, math.Float32bits(1)))
+	header.WriteByte(0)
+
+	linesPerChunk := compression.linesPerChunk()
+	var chunks [][]byte
+	for y0 := 0; y0 < imgHeight; y0 += linesPerChunk {
+		var raw []byte
+		for y := y0; y < min(y0+linesPerChunk, imgHeight); y++ {
+			for _, ch := range channels {
+				for _, v := range ch.data[y*imgWidth : (y+1)*imgWidth] {
+					raw = le.AppendUint32(raw, math.Float32bits(v))
+				}
+			}
+		}
+		if compression != exrNone {
+			compressed, err := zipExrChunk(raw)
+			if err != nil {
+				return err
+			}
+			if len(compressed) < len(raw) {
+				raw = compressed
+			}
+		}
+		chunk := le.AppendUint32(nil, uint32(y0))
+		chunk = le.AppendUint32(chunk, uint32(len(raw)))
+		chunks = append(chunks, append(chunk, raw...))
+	}
+
+	offset := uint64(header.Len() + 8*len(chunks))
+	for _, chunk := range chunks {
+		header.Write(le.AppendUint64(nil, offset))
+		offset += uint64(len(chunk))
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExrAttribute(header *bytes.Buffer, name, kind string, value []byte) {
+	header.WriteString(name)
+	header.WriteByte(0)
+	header.WriteString(kind)
+	header.WriteByte(0)
+	header.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(value))))
+	header.Write(value)
+}
+
+// Splits even and odd bytes into two halves and delta encodes the result
+// before deflating, as the EXR ZIP codecs expect.
+func zipExrChunk(raw []byte) ([]byte, error) {
+	tmp := make([]byte, len(raw))
+	half := (len(raw) + 1) / 2
+	for i, b := range raw {
+		if i%2 == 0 {
+			tmp[i/2] = b
+		} else {
+			tmp[half+i/2] = b
+		}
+	}
+	prev := tmp[0]
+	for i := 1; i < len(tmp); i++ {
+		cur := tmp[i]
+		tmp[i] = byte(int(cur) - int(prev) + 128 + 256)
+		prev = cur
+	}
+
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
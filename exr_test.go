@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"testing"
+)
+
+// Decodes the scanline EXR images encodeExr writes, returning the compression
+// and the channels by name.
+func decodeTestExr(data []byte) (exrCompression, map[string][]float32, error) {
+	le := binary.LittleEndian
+	if !bytes.HasPrefix(data, []byte{0x76, 0x2f, 0x31, 0x01}) {
+		return 0, nil, fmt.Errorf("bad magic %x", data[:4])
+	}
+	pos := 8
+	cstring := func() string {
+		end := pos + bytes.IndexByte(data[pos:], 0)
+		s := string(data[pos:end])
+		pos = end + 1
+		return s
+	}
+	var names []string
+	var compression exrCompression
+	var width, height int
+	for {
+		name := cstring()
+		if name == "" {
+			break
+		}
+		cstring()
+		size := int(le.Uint32(data[pos:]))
+		value := data[pos+4 : pos+4+size]
+		pos += 4 + size
+		switch name {
+		case "channels":
+			for value[0] != 0 {
+				end := bytes.IndexByte(value, 0)
+				names = append(names, string(value[:end]))
+				value = value[end+17:]
+			}
+		case "compression":
+			compression = exrCompression(value[0])
+		case "dataWindow":
+			width = int(le.Uint32(value[8:])) + 1
+			height = int(le.Uint32(value[12:])) + 1
+		}
+	}
+
+	channels := map[string][]float32{}
+	for _, name := range names {
+		channels[name] = make([]float32, width*height)
+	}
+	lines := compression.linesPerChunk()
+	chunks := (height + lines - 1) / lines
+	for i := range chunks {
+		offset := int(le.Uint64(data[pos+8*i:]))
+		y0 := int(le.Uint32(data[offset:]))
+		size := int(le.Uint32(data[offset+4:]))
+		raw := data[offset+8 : offset+8+size]
+		n := min(lines, height-y0)
+		if want := 4 * width * len(names) * n; compression != exrNone && size < want {
+			var err error
+			if raw, err = unzipTestExrChunk(raw); err != nil {
+				return 0, nil, err
+			}
+		}
+		for y := y0; y < y0+n; y++ {
+			for _, name := range names {
+				for x := range width {
+					channels[name][y*width+x] = math.Float32frombits(le.Uint32(raw))
+					raw = raw[4:]
+				}
+			}
+		}
+	}
+	return compression, channels, nil
+}
+
+func unzipTestExrChunk(compressed []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+	raw := make([]byte, len(tmp))
+	half := (len(tmp) + 1) / 2
+	for i := range raw {
+		if i%2 == 0 {
+			raw[i] = tmp[i/2]
+		} else {
+			raw[i] = tmp[half+i/2]
+		}
+	}
+	return raw, nil
+}
+
+func TestEncodeExr(t *testing.T) {
+	width, height := 40, 19 // Wide enough for single lines to compress, more than one sixteen line chunk
+	gradient := make([]vec3, width*height)
+	for i := range gradient {
+		gradient[i] = vec3{float64(i) / 10, 1, -float64(i)}
+	}
+	channels := append(vec3Channels("", gradient, "R", "G", "B"), imageChannel{name: "depth.Z", data: make([]float32, width*height)})
+	channels[3].data[7] = float32(math.Inf(1))
+
+	for _, compression := range exrCompressions {
+		t.Run(compression.name(), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeExr(&buf, channels, width, height, compression); err != nil {
+				t.Fatal(err)
+			}
+			gotCompression, decoded, err := decodeTestExr(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotCompression != compression {
+				t.Errorf("compression %d, want %d", gotCompression, compression)
+			}
+			for _, ch := range channels {
+				if !slices.Equal(decoded[ch.name], ch.data) {
+					t.Errorf("channel %s decoded as %v, want %v", ch.name, decoded[ch.name], ch.data)
+				}
+			}
+			if len(decoded) != len(channels) {
+				t.Errorf("decoded %d channels, want %d", len(decoded), len(channels))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+type film struct {
+	x0, y0        int       // Image coordinates of the film's top-left pixel
+	width, height int       // Film size in pixel count
+	color         []vec3    // Filter-weighted sum of sample colors per pixel
+	weight        []float64 // Sum of filter weights per pixel
+}
+
+func filmInit(x0, y0, width, height int) *film {
+	return &film{
+		x0:     x0,
+		y0:     y0,
+		width:  width,
+		height: height,
+		color:  make([]vec3, width*height),
+		weight: make([]float64, width*height),
+	}
+}
+
+func (f *film) clear() {
+	clear(f.color)
+	clear(f.weight)
+}
+
+// Adds a sample taken at continuous image coordinates (sx, sy) to every film
+// pixel within bounds whose center lies within the filter support.
+func (f *film) splat(flt filter, sx, sy float64, col vec3, bounds image.Rectangle) {
+	r := flt.support()
+	xMin := max(f.x0, bounds.Min.X, int(math.Ceil(sx-0.5-r)))
+	xMax := min(f.x0+f.width, bounds.Max.X, int(math.Floor(sx-0.5+r))+1) - 1
+	yMin := max(f.y0, bounds.Min.Y, int(math.Ceil(sy-0.5-r)))
+	yMax := min(f.y0+f.height, bounds.Max.Y, int(math.Floor(sy-0.5+r))+1) - 1
+
+	for y := yMin; y <= yMax; y++ {
+		for x := xMin; x <= xMax; x++ {
+			w := flt.weight(float64(x)+0.5-sx, float64(y)+0.5-sy)
+			if w == 0 {
+				continue
+			}
+			idx := (y-f.y0)*f.width + (x - f.x0)
+			f.color[idx] = f.color[idx].add(col.scale(w))
+			f.weight[idx] += w
+		}
+	}
+}
+
+func (f *film) merge(o *film) {
+	yMin, yMax := max(f.y0, o.y0), min(f.y0+f.height, o.y0+o.height)
+	xMin, xMax := max(f.x0, o.x0), min(f.x0+f.width, o.x0+o.width)
+	for y := yMin; y < yMax; y++ {
+		for x := xMin; x < xMax; x++ {
+			idx := (y-f.y0)*f.width + (x - f.x0)
+			oIdx := (y-o.y0)*o.width + (x - o.x0)
+			f.color[idx] = f.color[idx].add(o.color[oIdx])
+			f.weight[idx] += o.weight[oIdx]
+		}
+	}
+}
+
+func (f *film) resolve(x, y int) vec3 {
+	idx := (y-f.y0)*f.width + (x - f.x0)
+	if f.weight[idx] <= 0 {
+		return vec3{0, 0, 0}
+	}
+	return f.color[idx].divide(f.weight[idx])
+}
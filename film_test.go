@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFilmSplat(t *testing.T) {
+	col := vec3{1, 0.5, 0.25}
+	tests := []struct {
+		name        string
+		film        *film
+		filter      filter
+		sx, sy      float64
+		bounds      image.Rectangle
+		wantWeights map[image.Point]float64 // Weight per pixel, zero for pixels not listed
+	}{
+		{
+			name:        "box at pixel center",
+			film:        filmInit(0, 0, 3, 3),
+			filter:      boxFilter{radius: 0.5},
+			sx:          1.5,
+			sy:          1.5,
+			bounds:      image.Rect(0, 0, 3, 3),
+			wantWeights: map[image.Point]float64{{1, 1}: 1},
+		},
+		{
+			name:        "tent between pixels",
+			film:        filmInit(0, 0, 3, 3),
+			filter:      tentFilter{radius: 1},
+			sx:          1.25,
+			sy:          1.5,
+			bounds:      image.Rect(0, 0, 3, 3),
+			wantWeights: map[image.Point]float64{{0, 1}: 0.25, {1, 1}: 0.75},
+		},
+		{
+			name:        "clipped to bounds",
+			film:        filmInit(0, 0, 3, 3),
+			filter:      boxFilter{radius: 1},
+			sx:          1.5,
+			sy:          1.5,
+			bounds:      image.Rect(1, 1, 2, 3),
+			wantWeights: map[image.Point]float64{{1, 1}: 1, {1, 2}: 1},
+		},
+		{
+			name:        "offset film",
+			film:        filmInit(2, 2, 2, 2),
+			filter:      boxFilter{radius: 1},
+			sx:          2.5,
+			sy:          2.5,
+			bounds:      image.Rect(0, 0, 8, 8),
+			wantWeights: map[image.Point]float64{{2, 2}: 1, {3, 2}: 1, {2, 3}: 1, {3, 3}: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.film
+			f.splat(tt.filter, tt.sx, tt.sy, col, tt.bounds)
+			for y := range f.height {
+				for x := range f.width {
+					p := image.Pt(f.x0+x, f.y0+y)
+					i := y*f.width + x
+					want := tt.wantWeights[p]
+					if abs(f.weight[i]-want) > 1e-12 {
+						t.Errorf("pixel %v weighs %g, want %g", p, f.weight[i], want)
+					}
+					if f.color[i].subtract(col.scale(want)).l2() > 1e-12 {
+						t.Errorf("pixel %v has color %v, want %v", p, f.color[i], col.scale(want))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFilmMerge(t *testing.T) {
+	f := filmInit(0, 0, 4, 4)
+	tile := filmInit(1, 2, 4, 4)
+	for i := range tile.weight {
+		tile.color[i] = vec3{0.5, 1, 1.5} // Color {1, 2, 3} weighted by 0.5
+		tile.weight[i] = 0.5
+	}
+	f.merge(tile)
+	f.merge(tile)
+	for y := range 4 {
+		for x := range 4 {
+			want := 0.0
+			if x >= 1 && y >= 2 {
+				want = 1
+			}
+			if got := f.weight[y*4+x]; got != want {
+				t.Errorf("pixel (%d, %d) weighs %g, want %g", x, y, got, want)
+			}
+		}
+	}
+	if got := f.resolve(2, 3); got != (vec3{1, 2, 3}) {
+		t.Errorf("resolved %v, want the average sample color", got)
+	}
+	if got := f.resolve(0, 0); got != (vec3{0, 0, 0}) {
+		t.Errorf("resolved %v for a pixel without samples, want black", got)
+	}
+}
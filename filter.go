@@ -0,0 +1,130 @@
+package main
+
+import "math"
+
+type filter interface {
+	support() float64
+	weight(dx, dy float64) float64
+}
+
+var filterNames = []string{"box", "tent", "gaussian", "mitchell", "lanczos"}
+
+// Returns the named filter with the given radius, or its usual radius if 0.
+func filterByName(name string, radius float64) (filter, bool) {
+	usual := map[string]float64{"box": 0.5, "tent": 1, "gaussian": 1.5, "mitchell": 2, "lanczos": 3}
+	if radius == 0 {
+		radius = usual[name]
+	}
+	switch name {
+	case "box":
+		return boxFilter{radius: radius}, true
+	case "tent":
+		return tentFilter{radius: radius}, true
+	case "gaussian":
+		return gaussianFilter{radius: radius}, true
+	case "mitchell":
+		return mitchellFilter{radius: radius, b: 1.0 / 3, c: 1.0 / 3}, true
+	case "lanczos":
+		return lanczosFilter{radius: radius}, true
+	}
+	return nil, false
+}
+
+type boxFilter struct {
+	radius float64
+}
+
+func (f boxFilter) support() float64 {
+	return f.radius
+}
+
+func (f boxFilter) weight(dx, dy float64) float64 {
+	if abs(dx) > f.radius || abs(dy) > f.radius {
+		return 0
+	}
+	return 1
+}
+
+type tentFilter struct {
+	radius float64
+}
+
+func (f tentFilter) support() float64 {
+	return f.radius
+}
+
+func (f tentFilter) weight(dx, dy float64) float64 {
+	return math.Max(0, f.radius-abs(dx)) * math.Max(0, f.radius-abs(dy))
+}
+
+type gaussianFilter struct {
+	radius float64
+	alpha  float64 // Falloff rate, larger values give a sharper filter, 2 if 0
+}
+
+func (f gaussianFilter) support() float64 {
+	return f.radius
+}
+
+func (f gaussianFilter) weight(dx, dy float64) float64 {
+	return f.gaussian(dx) * f.gaussian(dy)
+}
+
+func (f gaussianFilter) gaussian(d float64) float64 {
+	alpha := f.alpha
+	if alpha == 0 {
+		alpha = 2
+	}
+	return math.Max(0, math.Exp(-alpha*d*d)-math.Exp(-alpha*f.radius*f.radius))
+}
+
+type mitchellFilter struct {
+	radius float64
+	b, c   float64 // Mitchell–Netravali parameters, 1/3 each is the recommended default
+}
+
+func (f mitchellFilter) support() float64 {
+	return f.radius
+}
+
+func (f mitchellFilter) weight(dx, dy float64) float64 {
+	return f.mitchell(dx/f.radius) * f.mitchell(dy/f.radius)
+}
+
+func (f mitchellFilter) mitchell(d float64) float64 {
+	x := abs(2 * d)
+	switch {
+	case x > 2:
+		return 0
+	case x > 1:
+		return ((-f.b-6*f.c)*x*x*x + (6*f.b+30*f.c)*x*x + (-12*f.b-48*f.c)*x + (8*f.b + 24*f.c)) / 6
+	default:
+		return ((12-9*f.b-6*f.c)*x*x*x + (-18+12*f.b+6*f.c)*x*x + (6 - 2*f.b)) / 6
+	}
+}
+
+type lanczosFilter struct {
+	radius float64
+}
+
+func (f lanczosFilter) support() float64 {
+	return f.radius
+}
+
+func (f lanczosFilter) weight(dx, dy float64) float64 {
+	return f.lanczos(dx) * f.lanczos(dy)
+}
+
+func (f lanczosFilter) lanczos(d float64) float64 {
+	if abs(d) > f.radius {
+		return 0
+	}
+	return sinc(d) * sinc(d/f.radius)
+}
+
+func sinc(x float64) float64 {
+	if abs(x) < 1e-5 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestFilterByName(t *testing.T) {
+	tests := []struct {
+		name        string
+		radius      float64
+		wantSupport float64
+	}{
+		{"box", 0, 0.5},
+		{"tent", 0, 1},
+		{"gaussian", 0, 1.5},
+		{"mitchell", 0, 2},
+		{"lanczos", 0, 3},
+		{"tent", 2.5, 2.5},
+	}
+	for _, tt := range tests {
+		f, ok := filterByName(tt.name, tt.radius)
+		if !ok {
+			t.Fatalf("filter %q not found", tt.name)
+		}
+		if f.support() != tt.wantSupport {
+			t.Errorf("%s with radius %g has support %g, want %g", tt.name, tt.radius, f.support(), tt.wantSupport)
+		}
+	}
+	if _, ok := filterByName("sinc", 0); ok {
+		t.Error("unknown filter name was accepted")
+	}
+}
+
+func TestFilterWeights(t *testing.T) {
+	for _, name := range filterNames {
+		f, _ := filterByName(name, 0)
+		r := f.support()
+		if w := f.weight(0, 0); w <= 0 {
+			t.Errorf("%s weighs %g at its center, want a positive weight", name, w)
+		}
+		for _, d := range [][2]float64{{r + 0.01, 0}, {0, r + 0.01}, {-r - 0.01, 0}, {r + 1, r + 1}} {
+			if w := f.weight(d[0], d[1]); w != 0 {
+				t.Errorf("%s weighs %g at %v beyond its support %g", name, w, d, r)
+			}
+		}
+		for _, d := range [][2]float64{{0.3, 0.1}, {0.7, -0.4}, {1.2, 0.5}} {
+			w := f.weight(d[0], d[1])
+			for _, mirrored := range [][2]float64{{-d[0], d[1]}, {d[0], -d[1]}, {d[1], d[0]}} {
+				if m := f.weight(mirrored[0], mirrored[1]); abs(m-w) > 1e-12 {
+					t.Errorf("%s weighs %g at %v but %g at %v", name, w, d, m, mirrored)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type flight struct {
+	path      string    // File flight paths are recorded to and replayed from
+	recording *timeline // Flight path being recorded, nil if not recording
+	playback  *timeline // Flight path being replayed, nil if not replaying
+	since     time.Time // Start of the current recording or replay
+	status    string    // Outcome of the last recording or replay, shown on screen
+}
+
+// Starts or stops recording and replaying the camera flight path on the
+// record and replay actions, R and P by default. Returns true while a replay
+// is driving the camera.
+func (g *game) updateFlight() bool {
+	f := &g.flight
+	if g.input.justPressed(toggleRecord) && f.playback == nil {
+		if f.recording == nil {
+			f.recording = &timeline{interpolation: linear}
+			f.since = time.Now()
+			f.status = "REC"
+		} else {
+			f.status = fmt.Sprintf("SAVED %d KEYS TO %s", len(f.recording.keyframes), f.path)
+			if err := saveTimeline(f.recording, f.path); err != nil {
+				f.status = err.Error()
+			}
+			f.recording = nil
+		}
+	}
+
+	if g.input.justPressed(toggleReplay) && f.recording == nil {
+		if f.playback == nil {
+			tl, err := loadTimeline(f.path)
+			if err != nil {
+				f.status = err.Error()
+			} else {
+				f.playback = tl
+				f.since = time.Now()
+				f.status = "PLAY"
+			}
+		} else {
+			f.playback = nil
+			f.status = ""
+		}
+	}
+
+	elapsed := time.Since(f.since).Seconds()
+	if f.recording != nil {
+		k := g.camera.pose()
+		k.time = elapsed
+		if n := len(f.recording.keyframes); n > 0 {
+			// Keep the roll continuous across ±180 degrees so it interpolates the short way.
+			previous := f.recording.keyframes[n-1].roll
+			k.roll = previous + math.Remainder(k.roll-previous, 360)
+		}
+		f.recording.keyframes = append(f.recording.keyframes, k)
+	}
+
+	if f.playback == nil {
+		return false
+	}
+	start := f.playback.keyframes[0].time
+	if elapsed > f.playback.duration() {
+		f.playback = nil
+		f.status = ""
+		return false
+	}
+	k := f.playback.at(start + elapsed)
+	g.camera.setPose(k)
+	return true
+}
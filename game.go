@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/atotto/clipboard"
@@ -16,15 +19,29 @@ type game struct {
 	camera     *camera
 	world      *world
 	fullscreen bool
+	width      int
+	height     int
 	mouse      mouse
 	fps        fps
+	flight     flight
+	edit       editor
+	watch      sceneWatch
+	resolution resolution
+	input      *inputProfile
+	models     []cameraModel // Camera models the cycle action steps through, the loaded lens last if any
+	velocity   vec3          // Camera velocity in camera space
+	lastUpdate time.Time     // Time of the previous update, to move at the same speed at any tick rate
 }
 
 type gameParams struct {
-	camera     *camera
-	world      *world
-	fpsCap     int
-	fullscreen bool
+	camera            *camera
+	world             *world
+	fpsCap            int
+	fullscreen        bool
+	flightPath        string
+	scenePath         string
+	dynamicResolution bool
+	input             *inputProfile // Key bindings and sensitivities, the defaults if nil
 }
 
 type fps struct {
@@ -40,66 +57,97 @@ type mouse struct {
 }
 
 func (g *game) Update() error {
-	if inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+	if g.input.justPressed(toggleFullscreen) {
 		g.fullscreen = !g.fullscreen
 		ebiten.SetFullscreen(g.fullscreen)
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+	if g.input.justPressed(toggleDenoise) {
+		g.camera.setDenoise(!g.camera.denoise)
+	}
+
+	if g.input.justPressed(cycleCameraModel) {
+		i := slices.IndexFunc(g.models, func(m cameraModel) bool { return m.name() == g.camera.model.name() })
+		g.camera.model = g.models[(i+1)%len(g.models)]
+	}
+
+	if g.input.justPressed(toggleCursor) {
+		if ebiten.CursorMode() == ebiten.CursorModeCaptured {
+			ebiten.SetCursorMode(ebiten.CursorModeVisible)
+		} else {
+			ebiten.SetCursorMode(ebiten.CursorModeCaptured)
+		}
+	}
+
+	if g.input.justPressed(quit) {
 		return errors.New("esc")
 	}
 
-	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) && ebiten.CursorMode() == ebiten.CursorModeCaptured {
 		err := clipboard.WriteAll(fmt.Sprintf("verticalFov: %.2f,\nlookFrom: %s,\nlookAt: %s,", g.camera.verticalFov, g.camera.center, g.camera.center.subtract(g.camera.w)))
 		if err != nil {
 			return errors.New("copy")
 		}
 	}
 
-	movement := vec3{0, 0, 0}
-	movementScale := 1.0
-	if ebiten.IsKeyPressed(ebiten.KeyControl) {
-		movementScale = 0.2
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyW) {
-		movement = movement.add(vec3{0, 0, -0.1})
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyS) {
-		movement = movement.add(vec3{0, 0, 0.05})
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyA) {
-		movement = movement.add(vec3{-0.05, 0, 0})
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyD) {
-		movement = movement.add(vec3{0.05, 0, 0})
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyShift) {
-		movement = movement.add(vec3{0, -0.05, 0})
-	}
-	if ebiten.IsKeyPressed(ebiten.KeySpace) {
-		movement = movement.add(vec3{0, 0.05, 0})
+	g.updateReload()
+	g.updateFocus()
+	g.updateEdit()
+
+	if g.input.justPressed(toggleFreeFly) {
+		g.camera.setFreeFly(!g.camera.freeFly)
 	}
-	movement = movement.scale(movementScale)
 
+	dt := min(0.1, time.Since(g.lastUpdate).Seconds())
+	g.lastUpdate = time.Now()
 	mx, my := ebiten.CursorPosition()
-	pitch, yaw := -float64(mx-g.mouse.x)*0.002, -float64(my-g.mouse.y)*0.002
-
-	fov := 0.0
-	if ebiten.IsKeyPressed(ebiten.KeyE) {
-		fov += 1
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyQ) {
-		fov -= 1
-	}
+	velocity, fov, yaw, pitch, roll := g.flightInput(mx, my, dt)
+	movement := g.flightMovement(velocity, dt)
 
 	g.updateFps()
-	g.camera.update(movement, fov, pitch, yaw)
+	if !g.updateFlight() {
+		g.camera.update(movement, fov, yaw, pitch, roll)
+	}
+	g.updateResolution()
+	start := time.Now()
 	g.camera.render(g.world)
-	g.img.WritePixels(g.camera.pixels)
+	g.resolution.renderTime = time.Since(start)
+	g.img.WritePixels(g.highlightSelection(g.camera.pixels))
 	g.mouse.x, g.mouse.y = mx, my
 	return nil
 }
 
+// The mouse wheel moves the plane of focus unless it is adjusting a material,
+// the aperture actions, brackets by default, open and close the aperture and
+// right click focuses on the object under the cursor, or under the center of
+// the screen while the cursor is captured.
+func (g *game) updateFocus() {
+	focalDistance, defocusAngle := g.camera.focalDistance, g.camera.defocusAngle
+	if _, wy := ebiten.Wheel(); wy != 0 && !g.input.pressed(wheelAdjustsParam) {
+		focalDistance *= math.Pow(1.1, wy)
+	}
+	if g.input.pressed(openAperture) {
+		defocusAngle += 0.2
+	}
+	if g.input.pressed(closeAperture) {
+		defocusAngle -= 0.2
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && g.width > 0 && g.height > 0 {
+		sx, sy := 0.5, 0.5
+		if ebiten.CursorMode() != ebiten.CursorModeCaptured {
+			mx, my := ebiten.CursorPosition()
+			sx, sy = float64(mx)/float64(g.width), float64(my)/float64(g.height)
+		}
+		_, s, t := g.camera.viewCoordinates(sx*float64(g.camera.imgWidth), sy*float64(g.camera.imgHeight))
+		if distance, ok := g.camera.focusDistanceAt(g.world, s, t); ok {
+			focalDistance = distance
+		}
+	}
+	if focalDistance != g.camera.focalDistance || defocusAngle != g.camera.defocusAngle {
+		g.camera.setFocus(focalDistance, defocusAngle)
+	}
+}
+
 func (g *game) updateFps() {
 	g.fps.count++
 	elapsed := time.Since(g.fps.since).Seconds()
@@ -119,10 +167,34 @@ func (g *game) Draw(screen *ebiten.Image) {
 	screen.DrawImage(g.img, opt)
 
 	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("FPS: %.2f", g.fps.average), 10, 10)
-	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("FOV:  %.2f\nFROM: %s\nAT:   %s", g.camera.verticalFov, g.camera.center, g.camera.center.subtract(g.camera.w)), 10, bounds.Dy()-60)
+	ebitenutil.DebugPrintAt(screen, strings.ToUpper(g.camera.model.name()), bounds.Dx()-120, 10)
+	if g.camera.denoise {
+		ebitenutil.DebugPrintAt(screen, "DENOISED", 10, 25)
+	}
+	if g.flight.status != "" {
+		ebitenutil.DebugPrintAt(screen, g.flight.status, 10, 40)
+	}
+	if g.edit.selected >= 0 {
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("SELECTED OBJECT %d", g.edit.selected), 10, 55)
+		ebitenutil.DebugPrintAt(screen, g.materialOverlay(), bounds.Dx()-200, 25)
+	}
+	if g.edit.status != "" {
+		ebitenutil.DebugPrintAt(screen, g.edit.status, 10, 70)
+	}
+	if g.camera.freeFly {
+		ebitenutil.DebugPrintAt(screen, "FREE FLY", bounds.Dx()-120, bounds.Dy()-45)
+	}
+	if g.camera.imgWidth != g.resolution.fullWidth {
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%dx%d", g.camera.imgWidth, g.camera.imgHeight), bounds.Dx()-120, bounds.Dy()-30)
+	}
+	if g.watch.status != "" {
+		ebitenutil.DebugPrintAt(screen, "SCENE RELOAD FAILED: "+g.watch.status, 10, 85)
+	}
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("FOV:  %.2f\nFROM: %s\nAT:   %s\nFOCUS: %.2f  APERTURE: %.2f", g.camera.verticalFov, g.camera.center, g.camera.center.subtract(g.camera.w), g.camera.focalDistance, g.camera.defocusAngle), 10, bounds.Dy()-75)
 }
 
 func (g *game) Layout(width, height int) (int, int) {
+	g.width, g.height = width, height
 	return width, height
 }
 
@@ -136,7 +208,21 @@ func gameInit(params gameParams) {
 		world:      params.world,
 		fullscreen: params.fullscreen,
 		fps:        fps{since: time.Now(), cap: params.fpsCap, averageRefreshRate: 1},
+		flight:     flight{path: params.flightPath},
+		edit:       editor{path: params.scenePath, selected: -1},
+		watch:      sceneWatch{path: params.scenePath},
+		input:      params.input,
+		models:     cameraModels,
+		lastUpdate: time.Now(),
+		resolution: resolution{enabled: params.dynamicResolution, fullWidth: params.camera.imgWidth, scale: 1},
+	}
+	if game.input == nil {
+		game.input = &defaultInputProfile
+	}
+	if lens, ok := params.camera.model.(*realisticLens); ok {
+		game.models = append(slices.Clone(cameraModels), lens)
 	}
+	game.watch.sync(params.camera.pose())
 
 	ebiten.SetWindowTitle("(RT)²")
 	ebiten.SetWindowSize(windowWidth, windowHeight)
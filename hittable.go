@@ -6,10 +6,12 @@ type hitRecord struct {
 	t         float64
 	frontFace bool
 	mat       material
+	objectID  int
 }
 
 type hittable interface {
 	hit(r ray, tInterval interval, record *hitRecord) bool
+	surface() material
 }
 
 func (hr *hitRecord) setFaceNormal(r ray, outwardUnitNormal vec3) {
@@ -20,3 +22,17 @@ func (hr *hitRecord) setFaceNormal(r ray, outwardUnitNormal vec3) {
 		hr.normal = outwardUnitNormal.scale(-1)
 	}
 }
+
+// Hittables the viewer can move, scale and rotate around their pivot.
+type transformable interface {
+	hittable
+	pivot() vec3
+	translated(offset vec3) transformable
+	scaled(factor float64) transformable
+	rotated(axis vec3, angle float64) transformable
+}
+
+// Hittables whose material the viewer can replace.
+type resurfaceable interface {
+	withSurface(mat material) hittable
+}
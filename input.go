@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+type action int
+
+const (
+	moveForward        action = iota // Fly towards the view direction
+	moveBack                         // Fly away from the view direction
+	moveLeft                         // Strafe left
+	moveRight                        // Strafe right
+	moveDown                         // Fly straight down
+	moveUp                           // Fly straight up
+	zoomIn                           // Narrow the field of view
+	zoomOut                          // Widen the field of view
+	moveSlowly                       // Scale down every movement while held
+	rollLeft                         // Roll counterclockwise in free fly mode
+	rollRight                        // Roll clockwise in free fly mode
+	toggleFreeFly                    // Switch between upright and free fly camera rotation
+	openAperture                     // Increase the defocus angle
+	closeAperture                    // Decrease the defocus angle
+	toggleFullscreen                 // Switch between windowed and fullscreen
+	toggleDenoise                    // Switch the denoiser on or off
+	cycleCameraModel                 // Switch to the next camera model
+	toggleCursor                     // Free or capture the mouse cursor
+	toggleRecord                     // Start or stop recording the flight path
+	toggleReplay                     // Start or stop replaying the flight path
+	undo                             // Undo the last scene edit
+	redo                             // Redo the last undone scene edit
+	saveEdits                        // Save the edited scene
+	nudgeLeft                        // Move the selection left across the view
+	nudgeRight                       // Move the selection right across the view
+	nudgeUp                          // Move the selection up
+	nudgeDown                        // Move the selection down
+	nudgeAway                        // Move the selection away from the camera
+	nudgeCloser                      // Move the selection towards the camera
+	growSelection                    // Scale the selection up
+	shrinkSelection                  // Scale the selection down
+	turnSelectionLeft                // Rotate the selection around the vertical axis
+	turnSelectionRight               // Rotate the selection the other way around the vertical axis
+	cycleMaterial                    // Switch the selection to the next material kind
+	selectParam1                     // Adjust the first material parameter
+	selectParam2                     // Adjust the second material parameter
+	selectParam3                     // Adjust the third material parameter
+	selectParam4                     // Adjust the fourth material parameter
+	decreaseParam                    // Decrease the material parameter being adjusted
+	increaseParam                    // Increase the material parameter being adjusted
+	wheelAdjustsParam                // Make the mouse wheel adjust the material parameter instead of the focus while held
+	quit                             // Close the viewer
+)
+
+var actionNames = []string{
+	"forward", "back", "left", "right", "down", "up", "zoomIn", "zoomOut", "slow", "rollLeft", "rollRight", "freeFly",
+	"openAperture", "closeAperture", "fullscreen", "denoise", "cameraModel", "cursor", "record", "replay",
+	"undo", "redo", "save", "nudgeLeft", "nudgeRight", "nudgeUp", "nudgeDown", "nudgeAway", "nudgeCloser", "grow", "shrink",
+	"turnLeft", "turnRight", "material", "param1", "param2", "param3", "param4", "decrease", "increase", "wheelAdjusts", "quit",
+}
+
+// Keys held together to trigger an action, the last one completing it.
+type keyChord []ebiten.Key
+
+type inputProfile struct {
+	bindings         [][]keyChord // Key chords bound to each action, indexed by action
+	forwardSpeed     float64      // Distance flown forwards per second
+	speed            float64      // Distance flown in any other direction per second
+	slowFactor       float64      // Movement scale while moving slowly
+	acceleration     float64      // Rate at which the camera reaches the requested speed, per second
+	damping          float64      // Rate at which the camera stops once no movement is requested, per second
+	zoomSpeed        float64      // Degrees of field of view changed per second
+	rollSpeed        float64      // Degrees rolled per second
+	mouseSensitivity float64      // Radians turned per pixel of mouse movement
+	stickSensitivity float64      // Radians turned per second with a gamepad stick fully tilted
+	deadzone         float64      // Gamepad stick tilt ignored as noise
+	invertY          bool         // Whether moving the mouse or stick up looks down
+}
+
+var defaultInputProfile = inputProfile{
+	bindings: [][]keyChord{
+		moveForward:        {{ebiten.KeyW}},
+		moveBack:           {{ebiten.KeyS}},
+		moveLeft:           {{ebiten.KeyA}},
+		moveRight:          {{ebiten.KeyD}},
+		moveDown:           {{ebiten.KeyShift}},
+		moveUp:             {{ebiten.KeySpace}},
+		zoomIn:             {{ebiten.KeyQ}},
+		zoomOut:            {{ebiten.KeyE}},
+		moveSlowly:         {{ebiten.KeyControl}},
+		rollLeft:           {{ebiten.KeyV}},
+		rollRight:          {{ebiten.KeyB}},
+		toggleFreeFly:      {{ebiten.KeyF}},
+		openAperture:       {{ebiten.KeyRightBracket}},
+		closeAperture:      {{ebiten.KeyLeftBracket}},
+		toggleFullscreen:   {{ebiten.KeyF11}},
+		toggleDenoise:      {{ebiten.KeyN}},
+		cycleCameraModel:   {{ebiten.KeyC}},
+		toggleCursor:       {{ebiten.KeyTab}},
+		toggleRecord:       {{ebiten.KeyR}},
+		toggleReplay:       {{ebiten.KeyP}},
+		undo:               {{ebiten.KeyControl, ebiten.KeyZ}},
+		redo:               {{ebiten.KeyControl, ebiten.KeyY}},
+		saveEdits:          {{ebiten.KeyF5}},
+		nudgeLeft:          {{ebiten.KeyArrowLeft}},
+		nudgeRight:         {{ebiten.KeyArrowRight}},
+		nudgeUp:            {{ebiten.KeyArrowUp}},
+		nudgeDown:          {{ebiten.KeyArrowDown}},
+		nudgeAway:          {{ebiten.KeyPageUp}},
+		nudgeCloser:        {{ebiten.KeyPageDown}},
+		growSelection:      {{ebiten.KeyEqual}, {ebiten.KeyKPAdd}},
+		shrinkSelection:    {{ebiten.KeyMinus}, {ebiten.KeyKPSubtract}},
+		turnSelectionLeft:  {{ebiten.KeyComma}},
+		turnSelectionRight: {{ebiten.KeyPeriod}},
+		cycleMaterial:      {{ebiten.KeyM}},
+		selectParam1:       {{ebiten.KeyDigit1}},
+		selectParam2:       {{ebiten.KeyDigit2}},
+		selectParam3:       {{ebiten.KeyDigit3}},
+		selectParam4:       {{ebiten.KeyDigit4}},
+		decreaseParam:      {{ebiten.KeySemicolon}},
+		increaseParam:      {{ebiten.KeyApostrophe}},
+		wheelAdjustsParam:  {{ebiten.KeyAlt}},
+		quit:               {{ebiten.KeyEscape}},
+	},
+	forwardSpeed:     3,
+	speed:            1.5,
+	slowFactor:       0.2,
+	acceleration:     12,
+	damping:          8,
+	zoomSpeed:        30,
+	rollSpeed:        90,
+	mouseSensitivity: 0.002,
+	stickSensitivity: 1.2,
+	deadzone:         0.15,
+}
+
+// Parses an input profile file, starting from the default profile. Each
+// non-empty line not starting with # either binds keys to an action, which
+// replaces its default keys, or sets an option
+//
+//	bind action chord...
+//	forwardSpeed|speed|slowFactor|acceleration|damping|zoomSpeed|rollSpeed value
+//	mouseSensitivity|stickSensitivity|deadzone value
+//	invertY true|false
+//
+// A chord is a key, or keys joined with + to be held together such as
+// Control+Z. Keys are named as in Ebiten, such as W, Space, ArrowUp or
+// Control.
+func parseInputProfile(r io.Reader) (*inputProfile, error) {
+	p := defaultInputProfile
+	p.bindings = slices.Clone(p.bindings)
+	options := map[string]*float64{
+		"forwardSpeed":     &p.forwardSpeed,
+		"speed":            &p.speed,
+		"slowFactor":       &p.slowFactor,
+		"acceleration":     &p.acceleration,
+		"damping":          &p.damping,
+		"zoomSpeed":        &p.zoomSpeed,
+		"rollSpeed":        &p.rollSpeed,
+		"mouseSensitivity": &p.mouseSensitivity,
+		"stickSensitivity": &p.stickSensitivity,
+		"deadzone":         &p.deadzone,
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch option := options[fields[0]]; {
+		case fields[0] == "bind":
+			if len(fields) < 3 || !slices.Contains(actionNames, fields[1]) {
+				return nil, fmt.Errorf("line %d: expected bind %s followed by keys", line, strings.Join(actionNames, "|"))
+			}
+			chords := make([]keyChord, len(fields)-2)
+			for i, field := range fields[2:] {
+				for _, name := range strings.Split(field, "+") {
+					var key ebiten.Key
+					if err := key.UnmarshalText([]byte(name)); err != nil {
+						return nil, fmt.Errorf("line %d: %w", line, err)
+					}
+					chords[i] = append(chords[i], key)
+				}
+			}
+			p.bindings[slices.Index(actionNames, fields[1])] = chords
+		case fields[0] == "invertY":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected invertY true|false", line)
+			}
+			invert, err := strconv.ParseBool(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			p.invertY = invert
+		case option != nil:
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected %s followed by a number", line, fields[0])
+			}
+			v, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			*option = v
+		default:
+			return nil, fmt.Errorf("line %d: unknown directive %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func loadInputProfile(path string) (*inputProfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseInputProfile(file)
+}
+
+func (p *inputProfile) pressed(a action) bool {
+	return slices.ContainsFunc(p.bindings[a], func(chord keyChord) bool {
+		return !slices.ContainsFunc(chord, func(key ebiten.Key) bool { return !ebiten.IsKeyPressed(key) })
+	})
+}
+
+// Reports whether a chord bound to the action was completed in this frame.
+func (p *inputProfile) justPressed(a action) bool {
+	return slices.ContainsFunc(p.bindings[a], func(chord keyChord) bool {
+		last := len(chord) - 1
+		return inpututil.IsKeyJustPressed(chord[last]) &&
+			!slices.ContainsFunc(chord[:last], func(key ebiten.Key) bool { return !ebiten.IsKeyPressed(key) })
+	})
+}
+
+// Returns the camera velocity, and the field of view change and rotation over
+// the last dt seconds, requested through the keyboard, the mouse while
+// captured and the sticks, shoulder buttons and triggers of any gamepad with
+// a standard layout.
+func (g *game) flightInput(mx, my int, dt float64) (velocity vec3, fov, yaw, pitch, roll float64) {
+	p := g.input
+	axes := map[action]vec3{
+		moveForward: {0, 0, -p.forwardSpeed},
+		moveBack:    {0, 0, p.speed},
+		moveLeft:    {-p.speed, 0, 0},
+		moveRight:   {p.speed, 0, 0},
+		moveDown:    {0, -p.speed, 0},
+		moveUp:      {0, p.speed, 0},
+	}
+	for a, v := range axes {
+		if p.pressed(a) {
+			velocity = velocity.add(v)
+		}
+	}
+	if p.pressed(zoomIn) {
+		fov -= p.zoomSpeed * dt
+	}
+	if p.pressed(zoomOut) {
+		fov += p.zoomSpeed * dt
+	}
+	if p.pressed(rollLeft) {
+		roll += deg2rad(p.rollSpeed) * dt
+	}
+	if p.pressed(rollRight) {
+		roll -= deg2rad(p.rollSpeed) * dt
+	}
+
+	lookY := 1.0
+	if p.invertY {
+		lookY = -1
+	}
+	if ebiten.CursorMode() == ebiten.CursorModeCaptured {
+		yaw = -float64(mx-g.mouse.x) * p.mouseSensitivity
+		pitch = -float64(my-g.mouse.y) * p.mouseSensitivity * lookY
+	}
+
+	slow := p.pressed(moveSlowly)
+	for _, id := range ebiten.AppendGamepadIDs(nil) {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+			continue
+		}
+		stick := func(axis ebiten.StandardGamepadAxis) float64 {
+			v := ebiten.StandardGamepadAxisValue(id, axis)
+			if math.Abs(v) < p.deadzone {
+				return 0
+			}
+			return v
+		}
+		button := func(b ebiten.StandardGamepadButton) float64 {
+			if ebiten.IsStandardGamepadButtonPressed(id, b) {
+				return 1
+			}
+			return 0
+		}
+
+		forward := -stick(ebiten.StandardGamepadAxisLeftStickVertical)
+		if forward > 0 {
+			forward *= p.forwardSpeed
+		} else {
+			forward *= p.speed
+		}
+		velocity = velocity.add(vec3{
+			stick(ebiten.StandardGamepadAxisLeftStickHorizontal) * p.speed,
+			(button(ebiten.StandardGamepadButtonFrontTopRight) - button(ebiten.StandardGamepadButtonFrontTopLeft)) * p.speed,
+			-forward,
+		})
+		fov += (button(ebiten.StandardGamepadButtonFrontBottomLeft) - button(ebiten.StandardGamepadButtonFrontBottomRight)) * p.zoomSpeed * dt
+		yaw -= stick(ebiten.StandardGamepadAxisRightStickHorizontal) * p.stickSensitivity * dt
+		pitch -= stick(ebiten.StandardGamepadAxisRightStickVertical) * p.stickSensitivity * lookY * dt
+		slow = slow || ebiten.IsStandardGamepadButtonPressed(id, ebiten.StandardGamepadButtonLeftStick)
+	}
+
+	if slow {
+		velocity = velocity.scale(p.slowFactor)
+	}
+	return velocity, fov, yaw, pitch, roll
+}
+
+// Eases the camera velocity towards the requested one over dt seconds,
+// accelerating while moving and damping once no movement is requested, and
+// returns the distance to move.
+func (g *game) flightMovement(requested vec3, dt float64) vec3 {
+	rate := g.input.acceleration
+	if requested == (vec3{0, 0, 0}) {
+		rate = g.input.damping
+	}
+	g.velocity = g.velocity.add(requested.subtract(g.velocity).scale(1 - math.Exp(-rate*dt)))
+	return g.velocity.scale(dt)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Lens surfaces are described in a lens-local space measured in scene units
+// where the film sits at z = 0 and the lens extends towards negative z, as in
+// pbrt's realistic camera (Pharr, Jakob and Humphreys, section 6.4).
+type lensElement struct {
+	curvatureRadius float64 // Signed radius of the spherical surface, 0 for the aperture stop
+	thickness       float64 // Distance along the optical axis to the next surface, or to the film for the last one
+	ior             float64 // Index of refraction of the medium behind the surface, 0 or 1 for air
+	apertureRadius  float64 // Radius of the surface's clear aperture
+}
+
+type realisticLens struct {
+	elements       []lensElement // Surfaces ordered from the scene side to the film side
+	rearThickness  float64       // Prescribed distance from the last surface to the film, before focusing
+	filmDiagonal   float64       // Diagonal of the film in scene units
+	focusedAt      float64       // Scene distance the lens was last focused at
+	filmWidth      float64       // Physical film width in scene units
+	filmHeight     float64       // Physical film height in scene units
+	exitPupils     []pupilBounds // Exit pupil bounds per film radius segment
+	exitPupilsArea float64       // Area of the exit pupil bounds at the film center
+}
+
+type pupilBounds struct {
+	xMin, yMin, xMax, yMax float64
+}
+
+const exitPupilSegments = 32
+
+func (l *realisticLens) name() string {
+	return "realistic"
+}
+
+// Parses a lens prescription: one surface per line as curvature radius,
+// thickness, index of refraction and aperture diameter, all lengths in
+// millimeters, ordered from the scene side. A radius of 0 marks the aperture
+// stop, whose diameter can be overridden with apertureDiameter if positive.
+// Lengths are converted to scene units assuming one unit is a meter.
+func parseLens(r io.Reader, filmDiagonal, apertureDiameter float64) (*realisticLens, error) {
+	l := &realisticLens{filmDiagonal: filmDiagonal * 0.001}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected radius, thickness, ior and aperture", line)
+		}
+		var v [4]float64
+		for i, field := range fields {
+			f, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			v[i] = f
+		}
+		if v[0] == 0 && apertureDiameter > 0 {
+			v[3] = min(v[3], apertureDiameter)
+		}
+		l.elements = append(l.elements, lensElement{
+			curvatureRadius: v[0] * 0.001,
+			thickness:       v[1] * 0.001,
+			ior:             v[2],
+			apertureRadius:  v[3] * 0.001 / 2,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(l.elements) == 0 {
+		return nil, errors.New("lens has no elements")
+	}
+	l.rearThickness = l.rearZ()
+	return l, nil
+}
+
+func loadLens(path string, filmDiagonal, apertureDiameter float64) (*realisticLens, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseLens(file, filmDiagonal, apertureDiameter)
+}
+
+// Returns a copy of the lens that can be focused independently, for cameras
+// rendering at the same time.
+func (l *realisticLens) clone() *realisticLens {
+	c := *l
+	c.elements = slices.Clone(l.elements)
+	return &c
+}
+
+// Returns the surfaces as prescribed, before focusing moved the film.
+func (l *realisticLens) prescription() []lensElement {
+	elements := slices.Clone(l.elements)
+	elements[len(elements)-1].thickness = l.rearThickness
+	return elements
+}
+
+// Fits the film to the camera's view, then focuses the lens on the camera's
+// focal distance and bounds its exit pupil, skipping that work if the focus
+// did not change. The exit pupil bounds only depend on the film diagonal, so
+// resizing the image, as the viewer does while moving, does not redo them.
+func (l *realisticLens) prepare(c *camera) {
+	viewWidth, viewHeight := c.viewSize()
+	aspect := viewHeight / viewWidth
+	l.filmWidth = math.Sqrt(l.filmDiagonal * l.filmDiagonal / (1 + aspect*aspect))
+	l.filmHeight = aspect * l.filmWidth
+	if l.focusedAt == c.focalDistance && l.exitPupils != nil {
+		return
+	}
+
+	if thickness, ok := l.focusThickLens(c.focalDistance); ok {
+		l.elements[len(l.elements)-1].thickness = thickness
+	}
+	l.focusedAt = c.focalDistance
+
+	l.exitPupils = make([]pupilBounds, exitPupilSegments)
+	var wg sync.WaitGroup
+	for i := range l.exitPupils {
+		wg.Add(1)
+		go func() {
+			r0 := float64(i) / exitPupilSegments * l.filmDiagonal / 2
+			r1 := float64(i+1) / exitPupilSegments * l.filmDiagonal / 2
+			l.exitPupils[i] = l.boundExitPupil(r0, r1)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	l.exitPupilsArea = l.exitPupils[0].area()
+}
+
+func (l *realisticLens) generateRay(c *camera, s, t, eye float64) (ray, float64) {
+	filmPoint := vec3{-(s - 0.5) * l.filmWidth, (t - 0.5) * l.filmHeight, 0}
+	rearPoint, pupilArea := l.sampleExitPupil(filmPoint.x, filmPoint.y)
+	filmRay := ray{ori: filmPoint, dir: rearPoint.subtract(filmPoint)}
+
+	sceneRay, ok := l.traceFromFilm(filmRay)
+	if !ok {
+		return ray{}, 0
+	}
+
+	cosTheta := filmRay.dir.normalize().z
+	weight := cosTheta * cosTheta * cosTheta * cosTheta * pupilArea / l.exitPupilsArea
+	toWorld := func(p vec3) vec3 {
+		return c.u.scale(p.x).add(c.v.scale(p.y)).subtract(c.w.scale(p.z))
+	}
+	return ray{ori: c.eyePosition(eye).add(toWorld(sceneRay.ori)), dir: toWorld(sceneRay.dir)}, weight
+}
+
+func (l *realisticLens) frontZ() float64 {
+	z := 0.0
+	for _, element := range l.elements {
+		z += element.thickness
+	}
+	return z
+}
+
+func (l *realisticLens) rearZ() float64 {
+	return l.elements[len(l.elements)-1].thickness
+}
+
+func (l *realisticLens) rearRadius() float64 {
+	return l.elements[len(l.elements)-1].apertureRadius
+}
+
+func mediumIor(ior float64) float64 {
+	if ior == 0 {
+		return 1
+	}
+	return ior
+}
+
+// Traces a camera-space ray leaving the film through every surface towards
+// the scene. Returns false if the ray is blocked by an aperture or totally
+// internally reflected.
+func (l *realisticLens) traceFromFilm(r ray) (ray, bool) {
+	elementZ := 0.0
+	r = ray{ori: vec3{r.ori.x, r.ori.y, -r.ori.z}, dir: vec3{r.dir.x, r.dir.y, -r.dir.z}}
+	for i := len(l.elements) - 1; i >= 0; i-- {
+		element := l.elements[i]
+		elementZ -= element.thickness
+
+		etaT := 1.0
+		if i > 0 {
+			etaT = mediumIor(l.elements[i-1].ior)
+		}
+		var ok bool
+		if r, ok = l.traceSurface(r, element, elementZ, mediumIor(element.ior), etaT); !ok {
+			return ray{}, false
+		}
+	}
+	return ray{ori: vec3{r.ori.x, r.ori.y, -r.ori.z}, dir: vec3{r.dir.x, r.dir.y, -r.dir.z}}, true
+}
+
+// Traces a camera-space ray entering the front of the lens through every
+// surface towards the film.
+func (l *realisticLens) traceFromScene(r ray) (ray, bool) {
+	elementZ := -l.frontZ()
+	r = ray{ori: vec3{r.ori.x, r.ori.y, -r.ori.z}, dir: vec3{r.dir.x, r.dir.y, -r.dir.z}}
+	for i, element := range l.elements {
+		etaI := 1.0
+		if i > 0 {
+			etaI = mediumIor(l.elements[i-1].ior)
+		}
+		var ok bool
+		if r, ok = l.traceSurface(r, element, elementZ, etaI, mediumIor(element.ior)); !ok {
+			return ray{}, false
+		}
+		elementZ += element.thickness
+	}
+	return ray{ori: vec3{r.ori.x, r.ori.y, -r.ori.z}, dir: vec3{r.dir.x, r.dir.y, -r.dir.z}}, true
+}
+
+// Intersects a lens-space ray with a surface whose vertex lies at elementZ and
+// refracts it from a medium of index etaI into one of index etaT.
+func (l *realisticLens) traceSurface(r ray, element lensElement, elementZ, etaI, etaT float64) (ray, bool) {
+	var t float64
+	var normal vec3
+	isStop := element.curvatureRadius == 0
+	if isStop {
+		if r.dir.z == 0 {
+			return ray{}, false
+		}
+		t = (elementZ - r.ori.z) / r.dir.z
+		if t < 0 {
+			return ray{}, false
+		}
+	} else {
+		var ok bool
+		t, normal, ok = intersectSphericalElement(r, element.curvatureRadius, elementZ+element.curvatureRadius)
+		if !ok {
+			return ray{}, false
+		}
+	}
+
+	hit := r.at(t)
+	if hit.x*hit.x+hit.y*hit.y > element.apertureRadius*element.apertureRadius {
+		return ray{}, false
+	}
+	if isStop {
+		return ray{ori: hit, dir: r.dir}, true
+	}
+
+	// Refract the ray into the next medium, flipping the direction convention
+	// so that the incident vector points away from the surface.
+	wi := r.dir.normalize().scale(-1)
+	eta := etaI / etaT
+	cosThetaI := normal.dot(wi)
+	sin2ThetaT := eta * eta * max(0, 1-cosThetaI*cosThetaI)
+	if sin2ThetaT >= 1 {
+		return ray{}, false
+	}
+	cosThetaT := math.Sqrt(1 - sin2ThetaT)
+	dir := wi.scale(-eta).add(normal.scale(eta*cosThetaI - cosThetaT))
+	return ray{ori: hit, dir: dir}, true
+}
+
+func intersectSphericalElement(r ray, radius, zCenter float64) (float64, vec3, bool) {
+	o := r.ori.subtract(vec3{0, 0, zCenter})
+	a := r.dir.l2Squared()
+	b := 2 * r.dir.dot(o)
+	c := o.l2Squared() - radius*radius
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return 0, vec3{}, false
+	}
+	discriminantSqrt := math.Sqrt(discriminant)
+	t0, t1 := (-b-discriminantSqrt)/(2*a), (-b+discriminantSqrt)/(2*a)
+
+	// The closer intersection is on the surface when travelling towards the
+	// sphere's center from the side of the vertex.
+	t := max(t0, t1)
+	if (r.dir.z > 0) != (radius < 0) {
+		t = min(t0, t1)
+	}
+	if t < 0 {
+		return 0, vec3{}, false
+	}
+
+	normal := o.add(r.dir.scale(t)).normalize()
+	if normal.dot(r.dir) > 0 {
+		normal = normal.scale(-1)
+	}
+	return t, normal, true
+}
+
+// Finds the film distance that focuses the lens at the given scene distance,
+// modelling the lens system as a thick lens through its cardinal points.
+func (l *realisticLens) focusThickLens(focusDistance float64) (float64, bool) {
+	x := 0.001 * l.filmDiagonal
+
+	sceneRay := ray{ori: vec3{x, 0, l.frontZ() + 1}, dir: vec3{0, 0, -1}}
+	filmRay, ok := l.traceFromScene(sceneRay)
+	if !ok {
+		return 0, false
+	}
+	principal0, focal0 := cardinalPoints(sceneRay, filmRay)
+
+	filmRay = ray{ori: vec3{x, 0, l.rearZ() - 1}, dir: vec3{0, 0, 1}}
+	sceneRay, ok = l.traceFromFilm(filmRay)
+	if !ok {
+		return 0, false
+	}
+	principal1, _ := cardinalPoints(filmRay, sceneRay)
+
+	f := focal0 - principal0
+	z := -focusDistance
+	c := (principal1 - z - principal0) * (principal1 - z - 4*f - principal0)
+	if c < 0 {
+		return 0, false
+	}
+	delta := 0.5 * (principal1 - z + principal0 - math.Sqrt(c))
+	return l.rearZ() + delta, true
+}
+
+func cardinalPoints(in, out ray) (principal, focal float64) {
+	tf := -out.ori.x / out.dir.x
+	focal = -out.at(tf).z
+	tp := (in.ori.x - out.ori.x) / out.dir.x
+	principal = -out.at(tp).z
+	return principal, focal
+}
+
+// Bounds the region of the rear element through which rays leaving film
+// points at distances between r0 and r1 from the center make it through
+// the lens.
+func (l *realisticLens) boundExitPupil(r0, r1 float64) pupilBounds {
+	const samples = 1 << 16
+	rear := 1.5 * l.rearRadius()
+	bounds := pupilBounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	exiting := 0
+	for i := range samples {
+		filmPoint := vec3{r0 + (r1-r0)*(float64(i)+0.5)/samples, 0, 0}
+		rearPoint := vec3{
+			-rear + 2*rear*radicalInverse(2, i),
+			-rear + 2*rear*radicalInverse(3, i),
+			l.rearZ(),
+		}
+		if bounds.contains(rearPoint.x, rearPoint.y) {
+			exiting++
+			continue
+		}
+		if _, ok := l.traceFromFilm(ray{ori: filmPoint, dir: rearPoint.subtract(filmPoint)}); ok {
+			bounds = bounds.union(rearPoint.x, rearPoint.y)
+			exiting++
+		}
+	}
+
+	if exiting == 0 {
+		return pupilBounds{-rear, -rear, rear, rear}
+	}
+	pad := 2 * math.Sqrt(8*rear*rear) / math.Sqrt(samples)
+	return pupilBounds{bounds.xMin - pad, bounds.yMin - pad, bounds.xMax + pad, bounds.yMax + pad}
+}
+
+// Picks a point on the rear element within the exit pupil bounds of a film
+// point, rotated from the x axis the bounds were computed along. Returns the
+// point and the area of the bounds it was sampled from.
+func (l *realisticLens) sampleExitPupil(filmX, filmY float64) (vec3, float64) {
+	filmRadius := math.Sqrt(filmX*filmX + filmY*filmY)
+	index := min(exitPupilSegments-1, int(filmRadius/(l.filmDiagonal/2)*exitPupilSegments))
+	bounds := l.exitPupils[index]
+	x := bounds.xMin + (bounds.xMax-bounds.xMin)*random()
+	y := bounds.yMin + (bounds.yMax-bounds.yMin)*random()
+
+	sinTheta, cosTheta := 0.0, 1.0
+	if filmRadius != 0 {
+		sinTheta, cosTheta = filmY/filmRadius, filmX/filmRadius
+	}
+	return vec3{cosTheta*x - sinTheta*y, sinTheta*x + cosTheta*y, l.rearZ()}, bounds.area()
+}
+
+func (b pupilBounds) contains(x, y float64) bool {
+	return b.xMin <= x && x <= b.xMax && b.yMin <= y && y <= b.yMax
+}
+
+func (b pupilBounds) union(x, y float64) pupilBounds {
+	return pupilBounds{min(b.xMin, x), min(b.yMin, y), max(b.xMax, x), max(b.yMax, y)}
+}
+
+func (b pupilBounds) area() float64 {
+	return (b.xMax - b.xMin) * (b.yMax - b.yMin)
+}
+
+func radicalInverse(base, i int) float64 {
+	inverse, factor := 0.0, 1.0/float64(base)
+	for ; i > 0; i /= base {
+		inverse += float64(i%base) * factor
+		factor /= float64(base)
+	}
+	return inverse
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Biconvex singlet with a 40 mm focal length behind an aperture stop, in
+// millimeters.
+const singletLens = `
+0 2 0 8
+40 4 1.5 12
+-40 40 0 12
+`
+
+func TestParseLens(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		apertureDiameter float64
+		want             []lensElement
+		wantErr          string
+	}{
+		{
+			name:  "millimeters to meters",
+			input: "# stop and singlet\n" + singletLens,
+			want: []lensElement{
+				{curvatureRadius: 0, thickness: 0.002, ior: 0, apertureRadius: 0.004},
+				{curvatureRadius: 0.04, thickness: 0.004, ior: 1.5, apertureRadius: 0.006},
+				{curvatureRadius: -0.04, thickness: 0.04, ior: 0, apertureRadius: 0.006},
+			},
+		},
+		{
+			name:             "stopped down",
+			input:            "0 2 0 8\n",
+			apertureDiameter: 4,
+			want:             []lensElement{{thickness: 0.002, apertureRadius: 0.002}},
+		},
+		{
+			name:             "stop not opened past its diameter",
+			input:            "0 2 0 8\n",
+			apertureDiameter: 20,
+			want:             []lensElement{{thickness: 0.002, apertureRadius: 0.004}},
+		},
+		{name: "missing field", input: "40 4 1.5\n", wantErr: "line 1"},
+		{name: "bad number", input: "40 4 1.5 12\n40 4 glass 12\n", wantErr: "line 2"},
+		{name: "empty", input: "# nothing\n", wantErr: "no elements"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := parseLens(strings.NewReader(tt.input), 35, tt.apertureDiameter)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(l.elements) != len(tt.want) {
+				t.Fatalf("parsed %d elements, want %d", len(l.elements), len(tt.want))
+			}
+			for i, e := range l.elements {
+				w := tt.want[i]
+				if abs(e.curvatureRadius-w.curvatureRadius) > 1e-12 || abs(e.thickness-w.thickness) > 1e-12 ||
+					e.ior != w.ior || abs(e.apertureRadius-w.apertureRadius) > 1e-12 {
+					t.Errorf("element %d is %+v, want %+v", i, e, w)
+				}
+			}
+			if l.rearThickness != l.rearZ() {
+				t.Errorf("prescribed rear thickness %g, want %g", l.rearThickness, l.rearZ())
+			}
+		})
+	}
+}
+
+func TestLensAxialRay(t *testing.T) {
+	l, err := parseLens(strings.NewReader(singletLens), 35, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := l.traceFromFilm(ray{ori: vec3{0, 0, 0}, dir: vec3{0, 0, 1}})
+	if !ok {
+		t.Fatal("axial ray was blocked")
+	}
+	if abs(r.ori.x) > 1e-12 || abs(r.ori.y) > 1e-12 || r.dir.normalize().subtract(vec3{0, 0, 1}).l2() > 1e-12 {
+		t.Errorf("axial ray left the lens as %+v, want it along the axis", r)
+	}
+}
+
+// Focuses the lens at several distances and checks that rays leaving the
+// film center converge there, much closer than at half or twice the
+// distance. The singlet is stopped down to 1 mm so that its spherical
+// aberration does not pull the marginal rays' focus away from the paraxial
+// one the thick lens model finds.
+func TestLensFocus(t *testing.T) {
+	for _, distance := range []float64{0.5, 2, 10} {
+		l, err := parseLens(strings.NewReader(singletLens), 35, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cameraInit(cameraParams{imgWidth: 8, aspectRatio: 1, verticalFov: 60, lookAt: vec3{0, 0, -1}, focalDistance: distance, antiAliasing: 1, model: l})
+		l.prepare(c)
+		if l.rearZ() == l.rearThickness {
+			t.Errorf("focusing at %g did not move the film", distance)
+		}
+
+		miss := func(d float64) float64 {
+			target := c.center.subtract(c.w.scale(d))
+			total, rays := 0.0, 0
+			for range 256 {
+				r, weight := l.generateRay(c, 0.5, 0.5, 0)
+				if weight == 0 {
+					continue
+				}
+				dir := r.dir.normalize()
+				total += target.subtract(r.ori).cross(dir).l2()
+				rays++
+			}
+			if rays == 0 {
+				t.Fatalf("no rays made it through the lens focused at %g", distance)
+			}
+			return total / float64(rays)
+		}
+		focused := miss(distance)
+		if near, far := miss(distance/2), miss(distance*2); focused > near/4 || focused > far/4 {
+			t.Errorf("focused at %g, rays miss it by %g, and by %g and %g at half and twice the distance", distance, focused, near, far)
+		}
+	}
+}
@@ -1,6 +1,123 @@
 package main
 
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
 func main() {
+	headless := flag.Bool("headless", false, "render a single image instead of opening the viewer")
+	output := flag.String("o", "./out/image.png", "output image path in headless mode, numbered per frame for timelines unless .gif or .apng, - writes to stdout")
+	format := flag.String("format", "png", "image format used when writing to stdout")
+	jpegQuality := flag.Int("quality", defaultImageOptions.jpegQuality, "JPEG quality from 1 to 100")
+	png16 := flag.Bool("png16", false, "write 16 bits per PNG channel")
+	ppmAscii := flag.Bool("ppm-ascii", false, "write PPM as ASCII P3 instead of binary P6")
+	exrCompressionName := flag.String("exr-compression", defaultImageOptions.exrCompression.name(), "EXR compression: none, zips for one scanline per chunk or zip for sixteen")
+	aovs := flag.Bool("aovs", false, "also write first-hit albedo, normal, depth, position and ID images next to headless output images")
+	denoise := flag.Bool("denoise", false, "denoise headless images guided by the AOVs, implies -aovs")
+	timelinePath := flag.String("timeline", "", "camera keyframe file to render as numbered frames in headless mode")
+	frames := flag.String("frames", "", "inclusive frame range to render from the timeline, e.g. 1:120, defaults to all of it")
+	fps := flag.Float64("fps", 24, "timeline frames per second")
+	dither := flag.Bool("dither", false, "dither animated GIF frames")
+	modelName := flag.String("camera", "perspective", "camera model: perspective, orthographic, fisheye, fisheye-equisolid or equirectangular")
+	lensPath := flag.String("lens", "", "lens prescription file, overrides the camera model with a realistic lens system")
+	filmDiagonal := flag.Float64("film-diagonal", 35, "film diagonal in millimeters for the realistic lens")
+	apertureDiameter := flag.Float64("aperture", 0, "aperture stop diameter in millimeters for the realistic lens, the prescription's if 0")
+	defocusAngle := flag.Float64("defocus", 0, "defocus angle in degrees of the thin lens, 0 for a pinhole")
+	blades := flag.Int("blades", 0, "number of aperture blades giving polygonal bokeh, circular if fewer than 3")
+	bladeRotation := flag.Float64("blade-rotation", 0, "rotation of the aperture blades in degrees")
+	apertureMaskPath := flag.String("aperture-mask", "", "grayscale image used as aperture transmission mask, overrides -blades")
+	squeeze := flag.Float64("squeeze", 1, "anamorphic squeeze factor, bokeh gets this many times taller than wide")
+	tilt := flag.Float64("tilt", 0, "tilt of the plane of focus in degrees around the horizontal axis")
+	shiftX := flag.Float64("shift-x", 0, "horizontal lens shift in viewport widths")
+	shiftY := flag.Float64("shift-y", 0, "vertical lens shift in viewport heights")
+	freeFly := flag.Bool("free-fly", false, "let the camera turn around its own axes, allowing roll and looking straight up")
+	roll := flag.Float64("roll", 0, "camera roll in degrees around the view direction, implies -free-fly")
+	filterName := flag.String("filter", "box", "pixel reconstruction filter: "+strings.Join(filterNames, ", "))
+	filterRadius := flag.Float64("filter-radius", 0, "reconstruction filter radius in pixels, the filter's usual radius if 0")
+	stereoName := flag.String("stereo", "mono", "stereo packing: mono, sbs (side-by-side) or ou (over-under)")
+	ipd := flag.Float64("ipd", 0.064, "stereo interpupillary distance in scene units")
+	convergence := flag.Float64("convergence", 0, "stereo convergence distance, the focal distance if 0")
+	flightPath := flag.String("flight", "./out/flight.txt", "timeline file the viewer records camera flights to (R) and replays from (P)")
+	scenePath := flag.String("scene", "", "scene file to render instead of the built-in scene, and that the viewer saves edits to (F5)")
+	dynamicResolution := flag.Bool("dynamic-resolution", true, "lower the viewer's render resolution while moving to hold its frame rate")
+	inputPath := flag.String("input", "", "viewer key bindings and sensitivity file, the defaults if empty")
+	progress := flag.Bool("progress", true, "draw a progress bar on stderr in headless mode")
+	stats := flag.Bool("stats", true, "write render statistics as JSON next to output images, with a .stats.json extension")
+	workers := flag.Int("workers", 0, "number of render goroutines, the number of CPUs if 0")
+	passes := flag.Int("passes", 0, "passes of samples to accumulate into a single headless image, each adding the samples per pixel once more, 1 unless -time-limit or -noise is given and unlimited then")
+	timeLimit := flag.Duration("time-limit", 0, "time after which a single headless image stops taking passes, keeping at least one, unlimited if 0")
+	noise := flag.Float64("noise", 0, "relative noise estimate a single headless image takes passes until, e.g. 0.02, none if 0")
+	checkpointPath := flag.String("checkpoint", "", "file a single headless image's film is periodically saved to and resumed from, extending it when given more -passes")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Minute, "time between checkpoints")
+	listen := flag.String("listen", "", "address such as :7000 to hand the tiles of a single headless image out to -worker processes connecting on")
+	workerAddr := flag.String("worker", "", "coordinator address to connect to and render tiles for, taking the scene and flags from it")
+	serve := flag.String("serve", "", "address such as :8080 to serve an HTTP API rendering posted scenes on instead of opening the viewer")
+	queueSize := flag.Int("queue", 16, "render jobs the HTTP API queues before refusing new ones")
+	concurrentJobs := flag.Int("concurrent-jobs", 1, "render jobs the HTTP API renders at the same time")
+	jobTTL := flag.Duration("job-ttl", time.Hour, "time the HTTP API keeps finished render jobs before forgetting them")
+	flag.Parse()
+
+	var job *DistributedJob
+	var client *rpc.Client
+	if *workerAddr != "" {
+		var err error
+		client, job, err = dialCoordinator(*workerAddr)
+		if err != nil {
+			panic(err)
+		}
+		if err := flag.CommandLine.Parse(job.Args); err != nil {
+			panic(err)
+		}
+	}
+
+	model, ok := cameraModelByName(*modelName)
+	if !ok {
+		panic(fmt.Sprintf("unknown camera model %q", *modelName))
+	}
+	if *lensPath != "" {
+		lens, err := loadLens(*lensPath, *filmDiagonal, *apertureDiameter)
+		if err != nil {
+			panic(err)
+		}
+		model = lens
+	}
+	pixelFilter, ok := filterByName(*filterName, *filterRadius)
+	if !ok {
+		panic(fmt.Sprintf("unknown filter %q", *filterName))
+	}
+	exrCompression, ok := exrCompressionByName(*exrCompressionName)
+	if !ok {
+		panic(fmt.Sprintf("unknown EXR compression %q", *exrCompressionName))
+	}
+	stereo := slices.Index(stereoLayoutNames, *stereoName)
+	if stereo < 0 {
+		panic(fmt.Sprintf("unknown stereo layout %q", *stereoName))
+	}
+	aperture := aperture{blades: *blades, rotation: *bladeRotation, squeeze: *squeeze}
+	if *apertureMaskPath != "" {
+		mask, err := loadApertureMask(*apertureMaskPath)
+		if err != nil {
+			panic(err)
+		}
+		aperture.mask = mask
+	}
+
 	world := &world{
 		objects: []hittable{
 			sphere{
@@ -30,28 +147,371 @@ func main() {
 			},
 		},
 	}
-
-	camera := cameraInit(cameraParams{
-		imgWidth:      200,
-		aspectRatio:   16.0 / 9.0,
-		verticalFov:   60.00,
+	pose := keyframe{
 		lookFrom:      vec3{-0.183, -0.168, -0.463},
 		lookAt:        vec3{0.572, -0.365, -1.088},
-		defocusAngle:  0,
+		verticalFov:   60.00,
 		focalDistance: 1,
-		antiAliasing:  1,
-		maxDepth:      10,
-	})
+	}
+	input := &defaultInputProfile
+	if *inputPath != "" {
+		profile, err := loadInputProfile(*inputPath)
+		if err != nil {
+			panic(err)
+		}
+		input = profile
+	}
 
-	defer close(camera.renderJobQueue)
+	savePath := "./out/scene.txt"
+	if job != nil {
+		scene, err := parseScene(strings.NewReader(job.Scene))
+		if err != nil {
+			panic(err)
+		}
+		world, pose = scene.world, *scene.camera
+	} else if *scenePath != "" {
+		scene, err := loadScene(*scenePath)
+		if err != nil {
+			panic(err)
+		}
+		world, savePath = scene.world, *scenePath
+		if scene.camera != nil {
+			pose = *scene.camera
+		}
+	}
+
+	params := cameraParams{
+		imgWidth:               200,
+		aspectRatio:            16.0 / 9.0,
+		verticalFov:            pose.verticalFov,
+		lookFrom:               pose.lookFrom,
+		lookAt:                 pose.lookAt,
+		defocusAngle:           *defocusAngle,
+		aperture:               aperture,
+		tilt:                   *tilt,
+		shiftHorizontal:        *shiftX,
+		shiftVertical:          *shiftY,
+		focalDistance:          pose.focalDistance,
+		antiAliasing:           1,
+		maxDepth:               10,
+		freeFly:                *freeFly,
+		roll:                   pose.roll + *roll,
+		model:                  model,
+		filter:                 pixelFilter,
+		aovs:                   *aovs,
+		denoise:                *denoise,
+		stereo:                 stereoLayout(stereo),
+		interpupillaryDistance: *ipd,
+		convergence:            *convergence,
+		workers:                *workers,
+		imageOptions: imageOptions{
+			ppmAscii:       *ppmAscii,
+			pngDepth16:     *png16,
+			jpegQuality:    *jpegQuality,
+			exrCompression: exrCompression,
+		},
+	}
+	camera := cameraInit(params)
 
-	if game := true; game {
-		gameInit(gameParams{camera: camera, world: world, fpsCap: 30, fullscreen: true})
+	// Interrupting a headless render stops it without writing partial images.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if client != nil {
+		if err := runWorker(client, camera, world); err != nil {
+			panic(err)
+		}
+	} else if *serve != "" {
+		server := renderServerInit(renderServerParams{
+			camera:     params,
+			queueSize:  *queueSize,
+			concurrent: *concurrentJobs,
+			ttl:        *jobTTL,
+		})
+		if err := http.ListenAndServe(*serve, server.handler()); err != nil {
+			panic(err)
+		}
+	} else if !*headless {
+		gameInit(gameParams{
+			camera:            camera,
+			world:             world,
+			fpsCap:            30,
+			fullscreen:        true,
+			flightPath:        *flightPath,
+			scenePath:         savePath,
+			dynamicResolution: *dynamicResolution,
+			input:             input,
+		})
+	} else if *timelinePath != "" {
+		err := renderSequence(ctx, camera, world, sequenceParams{
+			timelinePath: *timelinePath,
+			frames:       *frames,
+			fps:          *fps,
+			path:         *output,
+			format:       *format,
+			dither:       *dither,
+			progress:     *progress,
+			stats:        *stats,
+			roll:         *roll,
+		})
+		if err != nil {
+			panic(err)
+		}
 	} else {
-		camera.render(world)
-		err := camera.screenshot("./out/", "image.png")
+		ext := filepath.Ext(*output)
+		if *output == "-" {
+			ext = "." + strings.TrimPrefix(*format, ".")
+		}
+		if !slices.Contains(imageFormats, ext) {
+			panic(fmt.Sprintf("unsupported image format %q", ext))
+		}
+		if *passes == 0 && *timeLimit == 0 && *noise == 0 {
+			*passes = 1
+		}
+		if *listen != "" {
+			if camera.aovs != nil {
+				panic("AOVs are not rendered by workers, -aovs and -denoise cannot be combined with -listen")
+			}
+			co, err := listenCoordinator(*listen, &scene{world: world, camera: &pose})
+			if err != nil {
+				panic(err)
+			}
+			camera.coordinator = co
+			defer co.close()
+		}
+		err := renderPasses(ctx, camera, world, passesParams{
+			passes:             *passes,
+			timeLimit:          *timeLimit,
+			noise:              *noise,
+			checkpointPath:     *checkpointPath,
+			checkpointInterval: *checkpointInterval,
+			progress:           *progress,
+		})
 		if err != nil {
 			panic(err)
 		}
+		if err := saveOutput(camera, *output, *format); err != nil {
+			panic(err)
+		}
+		if *stats && *output != "-" {
+			if err := saveStats(camera.stats, *output); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+type passesParams struct {
+	passes             int           // Passes of samples the film should hold once done, unlimited if 0
+	timeLimit          time.Duration // Time after which the pass being rendered is abandoned, unlimited if 0
+	noise              float64       // Relative noise estimate to render until, none if 0
+	checkpointPath     string        // File the film is saved to and resumed from, none if empty
+	checkpointInterval time.Duration // Minimum time between checkpoints while rendering
+	progress           bool          // Whether to draw a progress bar per pass on stderr
+}
+
+// Renders the image in passes of samples until the film holds the requested
+// number, the time limit is reached or the noise estimate falls to the
+// target, whichever comes first, resuming from the checkpoint if it exists.
+// The first pass is always completed. The checkpoint is saved after passes
+// once the interval has elapsed, when done and when interrupted, so running
+// again with more passes extends a finished render.
+func renderPasses(ctx context.Context, camera *camera, world *world, params passesParams) error {
+	if params.passes == 0 && params.timeLimit == 0 && params.noise == 0 {
+		return errors.New("progressive render needs a pass count, time limit or noise target")
+	}
+	if params.checkpointPath != "" {
+		err := camera.loadCheckpoint(params.checkpointPath, world)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	limited, cancel := context.WithCancel(ctx)
+	if params.timeLimit > 0 {
+		limited, cancel = context.WithTimeout(ctx, params.timeLimit)
+	}
+	defer cancel()
+
+	var total renderStats
+	saved := time.Now()
+	developed := false
+	for {
+		if params.passes > 0 && camera.passes >= params.passes {
+			total.stoppedBy = "passes"
+		} else if params.noise > 0 && camera.variance.estimate() <= params.noise {
+			total.stoppedBy = "noise"
+		} else if camera.passes > 0 && limited.Err() != nil && ctx.Err() == nil {
+			total.stoppedBy = "time"
+		}
+		if total.stoppedBy != "" {
+			break
+		}
+
+		if params.progress {
+			label := "render"
+			if params.passes > 1 {
+				label = fmt.Sprintf("pass %d/%d", camera.passes+1, params.passes)
+			} else if params.passes != 1 {
+				label = fmt.Sprintf("pass %d", camera.passes+1)
+			}
+			camera.progress = progressBar(os.Stderr, label)
+		}
+		passCtx := ctx
+		if camera.passes > 0 {
+			passCtx = limited
+		}
+		err := camera.refine(passCtx, world)
+		total.add(camera.stats)
+		developed = err == nil
+		if err != nil && ctx.Err() == nil {
+			// The time limit ran out during the pass, whose samples are dropped
+			if params.progress {
+				fmt.Fprintln(os.Stderr)
+			}
+			total.stoppedBy = "time"
+			break
+		}
+		if err != nil {
+			if params.checkpointPath != "" && camera.passes > 0 {
+				return errors.Join(err, camera.saveCheckpoint(params.checkpointPath, world))
+			}
+			return err
+		}
+		if params.checkpointPath != "" && time.Since(saved) >= params.checkpointInterval {
+			if err := camera.saveCheckpoint(params.checkpointPath, world); err != nil {
+				return err
+			}
+			saved = time.Now()
+		}
+	}
+
+	if !developed {
+		camera.stats = renderStats{}
+		camera.develop()
+		total.add(camera.stats)
+	}
+	if params.checkpointPath != "" && total.traceTime > 0 {
+		if err := camera.saveCheckpoint(params.checkpointPath, world); err != nil {
+			return err
+		}
+	}
+	camera.stats = total
+	if params.passes != 1 {
+		fmt.Fprintf(os.Stderr, "stopped by %s after %d passes, %d samples per pixel, noise estimate %.4f, %.1fs\n",
+			total.stoppedBy, total.passes, total.samplesPerPixel, total.noise, total.traceTime.Seconds())
+	}
+	return nil
+}
+
+type sequenceParams struct {
+	timelinePath string  // Camera keyframe file
+	frames       string  // Inclusive frame range such as 1:120, all of the timeline if empty
+	fps          float64 // Timeline frames per second
+	path         string  // Output path, numbered per frame unless it is an animation
+	format       string  // Image format used when writing an animation to stdout
+	dither       bool    // Whether to dither animated GIF frames
+	progress     bool    // Whether to draw a progress bar per frame on stderr
+	stats        bool    // Whether to write render statistics next to each numbered frame
+	roll         float64 // Degrees of roll added to every keyframe's
+}
+
+// Renders timeline frames to numbered images, or to a single animated GIF or
+// APNG, where frame n shows the camera pose at (n-1)/fps seconds into the
+// timeline.
+func renderSequence(ctx context.Context, camera *camera, world *world, params sequenceParams) error {
+	ext := filepath.Ext(params.path)
+	if params.path == "-" {
+		ext = "." + strings.TrimPrefix(params.format, ".")
+	}
+	animated := slices.Contains(animationFormats, ext)
+	if params.path == "-" && !animated {
+		return errors.New("only animations can be written to stdout")
+	}
+	if !animated && !slices.Contains(imageFormats, ext) {
+		return fmt.Errorf("unsupported image format %q", ext)
+	}
+
+	tl, err := loadTimeline(params.timelinePath)
+	if err != nil {
+		return err
+	}
+
+	first, last := 1, 1+int(math.Floor(tl.duration()*params.fps))
+	if params.frames != "" {
+		if _, err := fmt.Sscanf(params.frames, "%d:%d", &first, &last); err != nil {
+			return fmt.Errorf("invalid frame range %q: %w", params.frames, err)
+		}
+	}
+
+	directory, fileName := filepath.Split(params.path)
+	if params.path != "-" {
+		// Fail before rendering rather than after the last frame
+		if err := os.MkdirAll(filepath.Dir(params.path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	var images []*image.RGBA
+	start := tl.keyframes[0].time
+	for frame := first; frame <= last; frame++ {
+		k := tl.at(start + float64(frame-1)/params.fps)
+		k.roll += params.roll
+		camera.setPose(k)
+		if params.progress {
+			camera.progress = progressBar(os.Stderr, fmt.Sprintf("frame %d/%d", frame, last))
+		}
+		if err := camera.renderContext(ctx, world); err != nil {
+			return err
+		}
+		if animated {
+			images = append(images, rgbaImage(slices.Clone(camera.pixels), camera.imgWidth, camera.imgHeight))
+			continue
+		}
+		framePath := filepath.Join(directory, frameFileName(fileName, frame))
+		if err := saveOutput(camera, framePath, ""); err != nil {
+			return err
+		}
+		if params.stats {
+			if err := saveStats(camera.stats, framePath); err != nil {
+				return err
+			}
+		}
+	}
+	if !animated {
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if params.path != "-" {
+		file, err := os.Create(params.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if ext == ".gif" {
+		return encodeGif(out, images, params.fps, params.dither)
+	}
+	return encodeApng(out, images, params.fps)
+}
+
+func saveOutput(camera *camera, path, format string) error {
+	if path == "-" {
+		stdout := bufio.NewWriter(os.Stdout)
+		if err := camera.encode(stdout, "."+strings.TrimPrefix(format, ".")); err != nil {
+			return err
+		}
+		return stdout.Flush()
+	}
+
+	directory, fileName := filepath.Split(path)
+	if err := camera.screenshot(directory, fileName); err != nil {
+		return err
+	}
+	if camera.aovs != nil {
+		return camera.screenshotAovs(directory, fileName)
 	}
+	return nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRenderPassesStop(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        passesParams
+		wantStoppedBy string
+		wantPasses    int // Passes the film should hold, at least one if 0
+		wantErr       bool
+	}{
+		{name: "single pass", params: passesParams{passes: 1}, wantStoppedBy: "passes", wantPasses: 1},
+		{name: "pass count", params: passesParams{passes: 3}, wantStoppedBy: "passes", wantPasses: 3},
+		// The estimate needs two passes before it is finite
+		{name: "noise target", params: passesParams{noise: 1e9}, wantStoppedBy: "noise", wantPasses: 2},
+		{name: "pass count before noise", params: passesParams{passes: 4, noise: 1e-9}, wantStoppedBy: "passes", wantPasses: 4},
+		// The first pass always completes
+		{name: "time limit", params: passesParams{timeLimit: time.Nanosecond}, wantStoppedBy: "time", wantPasses: 1},
+		{name: "no limit", params: passesParams{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := checkpointTestWorld()
+			c := checkpointTestCamera(t, false, false, 0)
+			err := renderPasses(context.Background(), c, w, tt.params)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("rendered without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.stats.stoppedBy != tt.wantStoppedBy {
+				t.Errorf("stopped by %q, want %q", c.stats.stoppedBy, tt.wantStoppedBy)
+			}
+			if c.passes != tt.wantPasses || c.stats.passes != tt.wantPasses {
+				t.Errorf("film holds %d passes and stats count %d, want %d", c.passes, c.stats.passes, tt.wantPasses)
+			}
+		})
+	}
+}
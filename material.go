@@ -4,6 +4,10 @@ import "math"
 
 type material interface {
 	scatter(rIn ray, hr *hitRecord, colorAttenuation *vec3, rOut *ray) bool
+	baseColor() vec3
+	name() string
+	params() []materialParam
+	withParam(i int, value float64) material
 }
 
 type lambertian struct {
@@ -20,6 +24,10 @@ func (l lambertian) scatter(rIn ray, hr *hitRecord, colorAttenuation *vec3, rOut
 	return true
 }
 
+func (l lambertian) baseColor() vec3 {
+	return l.albedo
+}
+
 type metal struct {
 	albedo vec3
 	fuzz   float64
@@ -32,6 +40,10 @@ func (m metal) scatter(rIn ray, hr *hitRecord, colorAttenuation *vec3, rOut *ray
 	return rOut.dir.dot(hr.normal) > 0
 }
 
+func (m metal) baseColor() vec3 {
+	return m.albedo
+}
+
 type dielectric struct {
 	refractionIndex float64
 }
@@ -56,8 +68,96 @@ func (d dielectric) scatter(rIn ray, hr *hitRecord, colorAttenuation *vec3, rOut
 	return true
 }
 
+func (d dielectric) baseColor() vec3 {
+	return vec3{1, 1, 1}
+}
+
 func (d dielectric) reflectance(cos, refractionIndex float64) float64 {
 	r0 := (1 - refractionIndex) / (1 + refractionIndex)
 	r0 = r0 * r0
 	return r0 + (1-r0)*math.Pow((1-cos), 5.0)
 }
+
+// Parameter of a material adjustable in the viewer's material editor.
+type materialParam struct {
+	name  string
+	value float64
+	valid interval // Range of values the parameter can take
+}
+
+var materialNames = []string{"lambertian", "metal", "dielectric"}
+
+// Returns a material of the named kind keeping the base color of m where it
+// applies.
+func convertMaterial(m material, name string) material {
+	switch name {
+	case "metal":
+		return metal{albedo: m.baseColor()}
+	case "dielectric":
+		return dielectric{refractionIndex: 1.5}
+	}
+	return lambertian{albedo: m.baseColor()}
+}
+
+func albedoParams(albedo vec3) []materialParam {
+	return []materialParam{
+		{name: "albedo r", value: albedo.x, valid: interval{0, 1}},
+		{name: "albedo g", value: albedo.y, valid: interval{0, 1}},
+		{name: "albedo b", value: albedo.z, valid: interval{0, 1}},
+	}
+}
+
+func withAlbedoParam(albedo vec3, i int, value float64) vec3 {
+	switch i {
+	case 0:
+		albedo.x = value
+	case 1:
+		albedo.y = value
+	case 2:
+		albedo.z = value
+	}
+	return albedo
+}
+
+func (l lambertian) name() string {
+	return "lambertian"
+}
+
+func (l lambertian) params() []materialParam {
+	return albedoParams(l.albedo)
+}
+
+func (l lambertian) withParam(i int, value float64) material {
+	l.albedo = withAlbedoParam(l.albedo, i, value)
+	return l
+}
+
+func (m metal) name() string {
+	return "metal"
+}
+
+func (m metal) params() []materialParam {
+	return append(albedoParams(m.albedo), materialParam{name: "fuzz", value: m.fuzz, valid: interval{0, 1}})
+}
+
+func (m metal) withParam(i int, value float64) material {
+	if i == 3 {
+		m.fuzz = value
+	} else {
+		m.albedo = withAlbedoParam(m.albedo, i, value)
+	}
+	return m
+}
+
+func (d dielectric) name() string {
+	return "dielectric"
+}
+
+func (d dielectric) params() []materialParam {
+	return []materialParam{{name: "refraction index", value: d.refractionIndex, valid: interval{0.1, 4}}}
+}
+
+func (d dielectric) withParam(i int, value float64) material {
+	d.refractionIndex = value
+	return d
+}
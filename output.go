@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+var imageFormats = []string{".ppm", ".png", ".jpg", ".jpeg", ".pfm", ".exr"}
+
+type imageOptions struct {
+	ppmAscii       bool           // Write PPM as ASCII P3 instead of binary P6
+	pngDepth16     bool           // Write 16 bits per PNG channel from the float image
+	jpegQuality    int            // JPEG quality from 1 to 100
+	exrCompression exrCompression // EXR chunk compression
+}
+
+var defaultImageOptions = imageOptions{
+	jpegQuality:    90,
+	exrCompression: exrZip,
+}
+
+// Encodes an image in the given format (a file extension such as ".png").
+// Display formats are written from the 8-bit RGBA pixels, float formats and
+// 16-bit PNG from the linear channels.
+func encodeImage(w io.Writer, format string, pixels []byte, channels []imageChannel, imgWidth, imgHeight int, options imageOptions) error {
+	switch format {
+	case ".ppm":
+		return encodePpm(w, pixels, imgWidth, imgHeight, options.ppmAscii)
+	case ".png":
+		if options.pngDepth16 {
+			return encodePng16(w, channels, imgWidth, imgHeight)
+		}
+		return png.Encode(w, rgbaImage(pixels, imgWidth, imgHeight))
+	case ".jpg", ".jpeg":
+		quality := options.jpegQuality
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, rgbaImage(pixels, imgWidth, imgHeight), &jpeg.Options{Quality: quality})
+	case ".pfm":
+		return encodePfm(w, channels, imgWidth, imgHeight)
+	case ".exr":
+		return encodeExr(w, channels, imgWidth, imgHeight, options.exrCompression)
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+func createImageFile(path string) (*os.File, error) {
+	if ext := filepath.Ext(path); !slices.Contains(imageFormats, ext) {
+		return nil, fmt.Errorf("unsupported image format %q", ext)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func rgbaImage(pixels []byte, imgWidth, imgHeight int) *image.RGBA {
+	return &image.RGBA{Pix: pixels, Stride: 4 * imgWidth, Rect: image.Rect(0, 0, imgWidth, imgHeight)}
+}
+
+func encodePpm(w io.Writer, pixels []byte, imgWidth, imgHeight int, ascii bool) error {
+	bw := bufio.NewWriter(w)
+	if ascii {
+		fmt.Fprintf(bw, "P3\n%d %d\n255\n", imgWidth, imgHeight)
+	} else {
+		fmt.Fprintf(bw, "P6\n%d %d\n255\n", imgWidth, imgHeight)
+	}
+
+	line := make([]byte, 0, 12)
+	for i := 0; i < 4*imgWidth*imgHeight; i += 4 {
+		if !ascii {
+			bw.Write(pixels[i : i+3])
+			continue
+		}
+		line = strconv.AppendUint(line[:0], uint64(pixels[i]), 10)
+		line = append(line, ' ')
+		line = strconv.AppendUint(line, uint64(pixels[i+1]), 10)
+		line = append(line, ' ')
+		line = strconv.AppendUint(line, uint64(pixels[i+2]), 10)
+		line = append(line, '\n')
+		bw.Write(line)
+	}
+	return bw.Flush()
+}
+
+func encodePng16(w io.Writer, channels []imageChannel, imgWidth, imgHeight int) error {
+	if len(channels) != 3 {
+		return fmt.Errorf("16-bit png needs 3 channels, got %d", len(channels))
+	}
+
+	img := image.NewRGBA64(image.Rect(0, 0, imgWidth, imgHeight))
+	for i := range imgWidth * imgHeight {
+		img.SetRGBA64(i%imgWidth, i/imgWidth, color.RGBA64{
+			R: linearToWord(channels[0].data[i]),
+			G: linearToWord(channels[1].data[i]),
+			B: linearToWord(channels[2].data[i]),
+			A: 0xffff,
+		})
+	}
+	return png.Encode(w, img)
+}
+
+func linearToWord(x float32) uint16 {
+	return uint16(math.Floor(65535.999 * math.Sqrt(interval{0, 1}.clamp(float64(x)))))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Writes a little-endian Portable FloatMap, grayscale for one channel and
+// color for three. Rows are stored bottom to top as the format requires.
+func encodePfm(w io.Writer, channels []imageChannel, imgWidth, imgHeight int) error {
+	var magic string
+	switch len(channels) {
+	case 1:
+		magic = "Pf"
+	case 3:
+		magic = "PF"
+	default:
+		return fmt.Errorf("pfm needs 1 or 3 channels, got %d", len(channels))
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s\n%d %d\n-1.0\n", magic, imgWidth, imgHeight); err != nil {
+		return err
+	}
+	var buf [4]byte
+	for y := imgHeight - 1; y >= 0; y-- {
+		for x := range imgWidth {
+			for _, ch := range channels {
+				binary.LittleEndian.PutUint32(buf[:], math.Float32bits(ch.data[y*imgWidth+x]))
+				if _, err := bw.Write(buf[:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
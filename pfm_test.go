@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestEncodePfm(t *testing.T) {
+	width, height := 2, 2
+	rgb := vec3Channels("", []vec3{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10, 11, 12}}, "R", "G", "B")
+	tests := []struct {
+		name       string
+		channels   []imageChannel
+		wantHeader string
+		wantValues []float32 // Samples in file order, bottom row first
+		wantErr    bool
+	}{
+		{
+			name:       "color",
+			channels:   rgb,
+			wantHeader: "PF\n2 2\n-1.0\n",
+			wantValues: []float32{7, 8, 9, 10, 11, 12, 1, 2, 3, 4, 5, 6},
+		},
+		{
+			name:       "grayscale",
+			channels:   rgb[1:2],
+			wantHeader: "Pf\n2 2\n-1.0\n",
+			wantValues: []float32{8, 11, 2, 5},
+		},
+		{
+			name:     "two channels",
+			channels: rgb[:2],
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := encodePfm(&buf, tt.channels, width, height)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("encoded without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+			if !bytes.HasPrefix(data, []byte(tt.wantHeader)) {
+				t.Fatalf("header %q, want %q", data[:min(len(data), len(tt.wantHeader))], tt.wantHeader)
+			}
+			data = data[len(tt.wantHeader):]
+			if len(data) != 4*len(tt.wantValues) {
+				t.Fatalf("%d bytes of samples, want %d", len(data), 4*len(tt.wantValues))
+			}
+			for i, want := range tt.wantValues {
+				if got := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])); got != want {
+					t.Errorf("sample %d is %g, want %g", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import "math"
+
+// Maps normalized view coordinates, s to the right and t downwards, both in
+// [0, 1], to a primary ray in the camera's frame of reference as seen from
+// the given eye (-1 left, 1 right, 0 mono), together with the weight of the
+// radiance it carries. The weight is zero for points outside of the model's
+// image area.
+type cameraModel interface {
+	generateRay(c *camera, s, t, eye float64) (ray, float64)
+	name() string
+}
+
+// Camera models that precompute state from the camera settings, called
+// before each render.
+type preparedCameraModel interface {
+	prepare(c *camera)
+}
+
+var cameraModels = []cameraModel{
+	perspective{},
+	orthographic{},
+	fisheye{equisolid: false},
+	fisheye{equisolid: true},
+	equirectangular{},
+}
+
+func cameraModelByName(name string) (cameraModel, bool) {
+	for _, model := range cameraModels {
+		if model.name() == name {
+			return model, true
+		}
+	}
+	return nil, false
+}
+
+// Pinhole or thin lens camera with a vertical field of view. Stereo eyes use
+// parallel axes with off-axis frusta meeting at the convergence distance.
+// Lens shift moves the frustum sideways without turning the camera, and tilt
+// rotates the plane of focus following the Scheimpflug principle.
+type perspective struct{}
+
+func (p perspective) name() string {
+	return "perspective"
+}
+
+func (p perspective) generateRay(c *camera, s, t, eye float64) (ray, float64) {
+	convergence := c.convergenceDistance()
+	viewportScale := convergence / c.focalDistance
+	convergencePoint := c.center.
+		subtract(c.w.scale(convergence)).
+		add(c.u.scale((s - 0.5 + c.shiftHorizontal) * c.viewportWidth * viewportScale)).
+		subtract(c.v.scale((t - 0.5 - c.shiftVertical) * c.viewportHeight * viewportScale))
+
+	eyePos := c.eyePosition(eye)
+	dir := convergencePoint.subtract(eyePos)
+	focusPlaneNormal := c.w.rotateAroundAxis(c.u, deg2rad(c.tilt))
+	focusPlanePoint := c.center.subtract(c.w.scale(c.focalDistance))
+	focusPoint := eyePos.add(dir.scale(focusPlanePoint.subtract(eyePos).dot(focusPlaneNormal) / dir.dot(focusPlaneNormal)))
+	rayOri := eyePos
+	if c.defocusAngle > 0 {
+		rayOri = c.randomPointOnDefocusDisk().add(eyePos.subtract(c.center))
+	}
+	return ray{ori: rayOri, dir: focusPoint.subtract(rayOri)}, 1
+}
+
+// Parallel projection covering the perspective viewport at the focal distance.
+type orthographic struct{}
+
+func (o orthographic) name() string {
+	return "orthographic"
+}
+
+func (o orthographic) generateRay(c *camera, s, t, eye float64) (ray, float64) {
+	rayOri := c.eyePosition(eye).
+		add(c.u.scale((s - 0.5) * c.viewportWidth)).
+		subtract(c.v.scale((t - 0.5) * c.viewportHeight))
+	return ray{ori: rayOri, dir: c.w.scale(-1)}, 1
+}
+
+// Circular fisheye whose image circle spans the image height and covers the
+// vertical field of view, with an equidistant or equisolid angle mapping.
+type fisheye struct {
+	equisolid bool
+}
+
+func (f fisheye) name() string {
+	if f.equisolid {
+		return "fisheye-equisolid"
+	}
+	return "fisheye"
+}
+
+func (f fisheye) generateRay(c *camera, s, t, eye float64) (ray, float64) {
+	viewWidth, viewHeight := c.viewSize()
+	x := (2*s - 1) * viewWidth / viewHeight
+	y := 1 - 2*t
+	r := math.Sqrt(x*x + y*y)
+	if r > 1 {
+		return ray{}, 0
+	}
+
+	thetaMax := deg2rad(c.verticalFov) / 2
+	theta := r * thetaMax
+	if f.equisolid {
+		theta = 2 * math.Asin(interval{-1, 1}.clamp(r*math.Sin(thetaMax/2)))
+	}
+	phi := math.Atan2(y, x)
+
+	dir := c.u.scale(math.Sin(theta) * math.Cos(phi)).
+		add(c.v.scale(math.Sin(theta) * math.Sin(phi))).
+		subtract(c.w.scale(math.Cos(theta)))
+	return ray{ori: c.eyePosition(eye), dir: dir}, 1
+}
+
+// Full 360° by 180° latitude-longitude panorama centered on the view
+// direction. Stereo eyes use omni-directional stereo.
+type equirectangular struct{}
+
+func (e equirectangular) name() string {
+	return "equirectangular"
+}
+
+func (e equirectangular) generateRay(c *camera, s, t, eye float64) (ray, float64) {
+	longitude := (s - 0.5) * 2 * math.Pi
+	latitude := (0.5 - t) * math.Pi
+	dir := c.u.scale(math.Cos(latitude) * math.Sin(longitude)).
+		add(c.v.scale(math.Sin(latitude))).
+		subtract(c.w.scale(math.Cos(latitude) * math.Cos(longitude)))
+	return ray{ori: c.odsEyePosition(eye, longitude), dir: dir}, 1
+}
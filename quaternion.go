@@ -0,0 +1,56 @@
+package main
+
+import "math"
+
+type quaternion struct {
+	w, x, y, z float64
+}
+
+func quaternionFromAxisAngle(axis vec3, angle float64) quaternion {
+	axis = axis.normalize()
+	sin := math.Sin(angle / 2)
+	return quaternion{math.Cos(angle / 2), axis.x * sin, axis.y * sin, axis.z * sin}
+}
+
+// Returns the rotation taking the x, y and z axes to the given orthonormal
+// right-handed basis.
+func quaternionFromBasis(u, v, w vec3) quaternion {
+	trace := u.x + v.y + w.z
+	var q quaternion
+	switch {
+	case trace > 0:
+		s := 2 * math.Sqrt(trace+1)
+		q = quaternion{s / 4, (v.z - w.y) / s, (w.x - u.z) / s, (u.y - v.x) / s}
+	case u.x > v.y && u.x > w.z:
+		s := 2 * math.Sqrt(1+u.x-v.y-w.z)
+		q = quaternion{(v.z - w.y) / s, s / 4, (v.x + u.y) / s, (w.x + u.z) / s}
+	case v.y > w.z:
+		s := 2 * math.Sqrt(1+v.y-u.x-w.z)
+		q = quaternion{(w.x - u.z) / s, (v.x + u.y) / s, s / 4, (w.y + v.z) / s}
+	default:
+		s := 2 * math.Sqrt(1+w.z-u.x-v.y)
+		q = quaternion{(u.y - v.x) / s, (w.x + u.z) / s, (w.y + v.z) / s, s / 4}
+	}
+	return q.normalize()
+}
+
+// Returns the rotation applying o first and then q.
+func (q quaternion) multiply(o quaternion) quaternion {
+	return quaternion{
+		q.w*o.w - q.x*o.x - q.y*o.y - q.z*o.z,
+		q.w*o.x + q.x*o.w + q.y*o.z - q.z*o.y,
+		q.w*o.y - q.x*o.z + q.y*o.w + q.z*o.x,
+		q.w*o.z + q.x*o.y - q.y*o.x + q.z*o.w,
+	}
+}
+
+func (q quaternion) normalize() quaternion {
+	l := math.Sqrt(q.w*q.w + q.x*q.x + q.y*q.y + q.z*q.z)
+	return quaternion{q.w / l, q.x / l, q.y / l, q.z / l}
+}
+
+func (q quaternion) rotate(v vec3) vec3 {
+	axis := vec3{q.x, q.y, q.z}
+	t := axis.cross(v).scale(2)
+	return v.add(t.scale(q.w)).add(axis.cross(t))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+type sceneWatch struct {
+	path    string    // Scene file being watched
+	modTime time.Time // Modification time of the last loaded or saved scene
+	checked time.Time // Last time the file was polled
+	camera  *keyframe // Camera pose of the last loaded scene, nil if it had none
+	status  string    // Error of the last reload, shown on screen
+}
+
+// Polls the scene file twice a second and swaps in the new world when it
+// changes. The viewer keeps its camera pose unless the file's camera changed.
+func (g *game) updateReload() {
+	sw := &g.watch
+	if time.Since(sw.checked) < 500*time.Millisecond {
+		return
+	}
+	sw.checked = time.Now()
+
+	info, err := os.Stat(sw.path)
+	if err != nil || info.ModTime().Equal(sw.modTime) {
+		return
+	}
+	sw.modTime = info.ModTime()
+
+	sc, err := loadScene(sw.path)
+	if err != nil {
+		sw.status = err.Error()
+		return
+	}
+	sw.status = ""
+	g.world = sc.world
+	g.edit = editor{path: g.edit.path, selected: -1}
+	if sc.camera != nil && (sw.camera == nil || *sc.camera != *sw.camera) {
+		g.camera.setPose(*sc.camera)
+	}
+	sw.camera = sc.camera
+}
+
+// Records the scene file as matching the viewer, so it isn't reloaded until
+// it changes again.
+func (sw *sceneWatch) sync(camera keyframe) {
+	if info, err := os.Stat(sw.path); err == nil {
+		sw.modTime = info.ModTime()
+	}
+	sw.camera = &camera
+}
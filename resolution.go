@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+type resolution struct {
+	enabled    bool          // Whether the render width adapts to the frame rate
+	fullWidth  int           // Render width while the camera is still
+	scale      float64       // Fraction of the full width rendered while the camera moves
+	renderTime time.Duration // Time taken to render the last frame
+	pose       keyframe      // Camera pose of the last frame
+}
+
+// Lowers the render width while the last frame took longer than the frame
+// budget of the fps cap and raises it while well within it, snapping back to
+// the full width while the camera is still.
+func (g *game) updateResolution() {
+	r := &g.resolution
+	if !r.enabled {
+		return
+	}
+
+	budget := time.Second / time.Duration(g.fps.cap)
+	switch {
+	case r.renderTime > budget*9/10:
+		r.scale = max(0.25, r.scale*0.9)
+	case r.renderTime < budget/2:
+		r.scale = min(1, r.scale*1.1)
+	}
+
+	width := int(float64(r.fullWidth) * r.scale)
+	pose := g.camera.pose()
+	if pose == r.pose {
+		width = r.fullWidth
+	}
+	r.pose = pose
+
+	g.camera.resize(max(16, width))
+	if g.img.Bounds().Dx() != g.camera.imgWidth || g.img.Bounds().Dy() != g.camera.imgHeight {
+		g.img.Deallocate()
+		g.img = ebiten.NewImage(g.camera.imgWidth, g.camera.imgHeight)
+	}
+}
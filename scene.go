@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type scene struct {
+	world  *world
+	camera *keyframe // Initial camera pose, nil to leave the camera as is
+}
+
+// Parses a scene file. Each non-empty line not starting with # is either the
+// camera pose or an object
+//
+//	camera fromX fromY fromZ atX atY atZ verticalFov focalDistance [roll]
+//	sphere centerX centerY centerZ radius material
+//
+// where roll defaults to 0 and material is one of
+//
+//	lambertian r g b
+//	metal r g b fuzz
+//	dielectric refractionIndex
+func parseScene(r io.Reader) (*scene, error) {
+	w := &world{}
+	sc := &scene{world: w}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "camera" {
+			if len(fields) != 9 && len(fields) != 10 {
+				return nil, fmt.Errorf("line %d: expected camera followed by 8 or 9 numbers", line)
+			}
+			v, err := parseFloats(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			var values [9]float64
+			copy(values[:], v)
+			k := keyframeFromValues(0, values)
+			sc.camera = &k
+			continue
+		}
+		if fields[0] != "sphere" {
+			return nil, fmt.Errorf("line %d: unknown object %q", line, fields[0])
+		}
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("line %d: expected sphere followed by 4 numbers and a material", line)
+		}
+		v, err := parseFloats(fields[1:5])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		mat, err := parseMaterial(fields[5:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		w.objects = append(w.objects, sphere{center: vec3{v[0], v[1], v[2]}, radius: v[3], mat: mat})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(w.objects) == 0 {
+		return nil, errors.New("scene has no objects")
+	}
+	return sc, nil
+}
+
+func parseMaterial(fields []string) (material, error) {
+	v, err := parseFloats(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fields[0] == "lambertian" && len(v) == 3:
+		return lambertian{albedo: vec3{v[0], v[1], v[2]}}, nil
+	case fields[0] == "metal" && len(v) == 4:
+		return metal{albedo: vec3{v[0], v[1], v[2]}, fuzz: v[3]}, nil
+	case fields[0] == "dielectric" && len(v) == 1:
+		return dielectric{refractionIndex: v[0]}, nil
+	}
+	return nil, fmt.Errorf("invalid material %q", strings.Join(fields, " "))
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	v := make([]float64, len(fields))
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+func loadScene(path string) (*scene, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseScene(file)
+}
+
+func (sc *scene) encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if sc.camera != nil {
+		fmt.Fprint(bw, "camera")
+		for _, v := range sc.camera.values() {
+			fmt.Fprintf(bw, " %g", v)
+		}
+		fmt.Fprintln(bw)
+	}
+	for _, object := range sc.world.objects {
+		s, ok := object.(sphere)
+		if !ok {
+			return fmt.Errorf("cannot encode object of type %T", object)
+		}
+		fmt.Fprintf(bw, "sphere %g %g %g %g ", s.center.x, s.center.y, s.center.z, s.radius)
+		switch m := s.mat.(type) {
+		case lambertian:
+			fmt.Fprintf(bw, "lambertian %g %g %g\n", m.albedo.x, m.albedo.y, m.albedo.z)
+		case metal:
+			fmt.Fprintf(bw, "metal %g %g %g %g\n", m.albedo.x, m.albedo.y, m.albedo.z, m.fuzz)
+		case dielectric:
+			fmt.Fprintf(bw, "dielectric %g\n", m.refractionIndex)
+		default:
+			return fmt.Errorf("cannot encode material of type %T", s.mat)
+		}
+	}
+	return bw.Flush()
+}
+
+func saveScene(sc *scene, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return sc.encode(file)
+}
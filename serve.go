@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxSceneSize = 1 << 20
+
+type renderJob struct {
+	id        string
+	status    string       // queued, rendering, done, failed or cancelled
+	err       error        // Why the job failed
+	params    cameraParams // Settings the camera is created with once the job starts rendering
+	camera    *camera      // Camera rendering the job, nil while queued
+	world     *world
+	ctx       context.Context
+	cancel    context.CancelFunc
+	tilesDone int // Tiles rendered so far
+	tiles     int // Tiles the image is split into
+}
+
+type renderServerParams struct {
+	camera     cameraParams  // Camera of every job, posed by the camera line of the job's scene if it has one
+	queueSize  int           // Jobs waiting to render beyond which new jobs are refused
+	concurrent int           // Jobs rendering at the same time
+	ttl        time.Duration // Time finished jobs are kept before being forgotten
+}
+
+// Renders scenes posted over HTTP, queueing them as jobs rendered a few at a
+// time.
+type renderServer struct {
+	camera cameraParams
+	ttl    time.Duration
+	queue  chan *renderJob
+	mu     sync.Mutex // Guards jobs, nextID and the state of every job
+	jobs   map[string]*renderJob
+	nextID int
+}
+
+func renderServerInit(params renderServerParams) *renderServer {
+	s := &renderServer{
+		camera: params.camera,
+		ttl:    params.ttl,
+		queue:  make(chan *renderJob, params.queueSize),
+		jobs:   map[string]*renderJob{},
+	}
+	for range params.concurrent {
+		go func() {
+			for job := range s.queue {
+				s.run(job)
+			}
+		}()
+	}
+	return s
+}
+
+// Returns the HTTP API of the server
+//
+//	POST   /jobs[?width=n]       queue the scene file in the body, answering with the job status
+//	GET    /jobs/{id}            job status and progress
+//	GET    /jobs/{id}/image.ext  rendered image, as png, jpg, ppm, pfm or exr
+//	DELETE /jobs/{id}            cancel the job, or forget it once finished
+//
+// where the status is JSON with the job's id, status (queued, rendering,
+// done, failed or cancelled), progress from 0 to 1 and error if it failed.
+// Finished jobs are forgotten once the server's TTL has passed.
+func (s *renderServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.createJob)
+	mux.HandleFunc("GET /jobs/{id}", s.jobStatus)
+	mux.HandleFunc("GET /jobs/{id}/{file}", s.jobImage)
+	mux.HandleFunc("DELETE /jobs/{id}", s.deleteJob)
+	return mux
+}
+
+func (s *renderServer) createJob(w http.ResponseWriter, r *http.Request) {
+	sc, err := parseScene(http.MaxBytesReader(w, r.Body, maxSceneSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := s.camera
+	if width := r.URL.Query().Get("width"); width != "" {
+		params.imgWidth, err = strconv.Atoi(width)
+		if err != nil || params.imgWidth < 1 || params.imgWidth > 8192 {
+			http.Error(w, fmt.Sprintf("invalid width %q", width), http.StatusBadRequest)
+			return
+		}
+	}
+	if sc.camera != nil {
+		params.lookFrom, params.lookAt = sc.camera.lookFrom, sc.camera.lookAt
+		params.verticalFov, params.focalDistance = sc.camera.verticalFov, sc.camera.focalDistance
+		// The scene's pose replaces the server's, roll included
+		params.freeFly, params.roll = false, sc.camera.roll
+	}
+
+	job := &renderJob{status: "queued", params: params, world: sc.world}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.queue <- job:
+	default:
+		job.cancel()
+		http.Error(w, "job queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	s.nextID++
+	job.id = strconv.Itoa(s.nextID)
+	s.jobs[job.id] = job
+	w.Header().Set("Location", "/jobs/"+job.id)
+	s.writeStatus(w, http.StatusAccepted, job)
+}
+
+// Renders a queued job, creating its camera only now so that queued jobs
+// hold no image buffers.
+func (s *renderServer) run(job *renderJob) {
+	s.mu.Lock()
+	if job.status != "queued" {
+		s.expire(job)
+		s.mu.Unlock()
+		return
+	}
+	job.status = "rendering"
+	s.mu.Unlock()
+
+	params := job.params
+	if lens, ok := params.model.(*realisticLens); ok {
+		params.model = lens.clone()
+	}
+	c := cameraInit(params)
+	c.progress = func(done, total int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		job.tilesDone, job.tiles = done, total
+	}
+	err := c.renderContext(job.ctx, job.world)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case job.ctx.Err() != nil:
+		job.status = "cancelled"
+	case err != nil:
+		job.status, job.err = "failed", err
+	default:
+		job.status = "done"
+	}
+	if job.status == "done" {
+		job.camera = c
+	}
+	job.world = nil
+	job.cancel()
+	s.expire(job)
+}
+
+// Forgets the finished job once the TTL has passed, unless it was deleted
+// before.
+func (s *renderServer) expire(job *renderJob) {
+	time.AfterFunc(s.ttl, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.jobs[job.id] == job {
+			delete(s.jobs, job.id)
+		}
+	})
+}
+
+// Returns the job named in the request path, answering with an error if
+// there is none. Must be called with the lock held.
+func (s *renderServer) job(w http.ResponseWriter, r *http.Request) (*renderJob, bool) {
+	job, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+	}
+	return job, ok
+}
+
+func (s *renderServer) jobStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.job(w, r); ok {
+		s.writeStatus(w, http.StatusOK, job)
+	}
+}
+
+func (s *renderServer) jobImage(w http.ResponseWriter, r *http.Request) {
+	format := filepath.Ext(r.PathValue("file"))
+	if r.PathValue("file") != "image"+format || !slices.Contains(imageFormats, format) {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	job, ok := s.job(w, r)
+	if ok && job.status != "done" {
+		http.Error(w, "job is "+job.status, http.StatusConflict)
+		ok = false
+	}
+	var c *camera
+	if ok {
+		c = job.camera
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := c.encode(&buf, format); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if contentType, ok := map[string]string{".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg"}[format]; ok {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Write(buf.Bytes())
+}
+
+func (s *renderServer) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	switch job.status {
+	case "queued":
+		job.status = "cancelled"
+		job.world = nil
+		job.cancel()
+	case "rendering":
+		job.cancel()
+	default:
+		delete(s.jobs, job.id)
+	}
+	s.writeStatus(w, http.StatusOK, job)
+}
+
+// Writes the job status as JSON. Must be called with the lock held.
+func (s *renderServer) writeStatus(w http.ResponseWriter, code int, job *renderJob) {
+	progress := 0.0
+	if job.status == "done" {
+		progress = 1
+	} else if job.tiles > 0 {
+		progress = float64(job.tilesDone) / float64(job.tiles)
+	}
+	errMessage := ""
+	if job.err != nil {
+		errMessage = job.err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		ID       string  `json:"id"`
+		Status   string  `json:"status"`
+		Progress float64 `json:"progress"`
+		Error    string  `json:"error,omitempty"`
+	}{job.id, job.status, progress, errMessage})
+}
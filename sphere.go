@@ -35,3 +35,31 @@ func (s sphere) hit(r ray, tInterval interval, hr *hitRecord) bool {
 	hr.mat = s.mat
 	return true
 }
+
+func (s sphere) surface() material {
+	return s.mat
+}
+
+func (s sphere) pivot() vec3 {
+	return s.center
+}
+
+func (s sphere) translated(offset vec3) transformable {
+	s.center = s.center.add(offset)
+	return s
+}
+
+func (s sphere) scaled(factor float64) transformable {
+	s.radius *= factor
+	return s
+}
+
+// Spheres look the same under any rotation around their center.
+func (s sphere) rotated(axis vec3, angle float64) transformable {
+	return s
+}
+
+func (s sphere) withSurface(mat material) hittable {
+	s.mat = mat
+	return s
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Counts of the work done tracing rays, kept per tile by each worker and
+// merged into the camera's totals.
+type rayCounts struct {
+	primaryRays       int64 // Rays leaving the camera
+	secondaryRays     int64 // Rays scattered off surfaces
+	intersectionTests int64 // Ray against object intersection tests
+}
+
+func (rc *rayCounts) merge(o rayCounts) {
+	rc.primaryRays += o.primaryRays
+	rc.secondaryRays += o.secondaryRays
+	rc.intersectionTests += o.intersectionTests
+}
+
+type renderStats struct {
+	rayCounts
+	tiles       int           // Tiles the image was split into
+	traceTime   time.Duration // Time spent tracing rays into the film
+	developTime time.Duration // Time spent resolving the film into pixels, excluding denoising
+	denoiseTime time.Duration // Time spent denoising
+
+	passes          int     // Passes of samples in the developed image
+	samplesPerPixel int     // Samples per pixel in the developed image
+	noise           float64 // Estimated relative noise of the developed image, infinite before two passes
+	stoppedBy       string  // Limit that ended a progressive render: passes, time or noise
+}
+
+// Adds the work of another render of the same image, such as a later pass.
+func (s *renderStats) add(o renderStats) {
+	s.merge(o.rayCounts)
+	s.tiles = o.tiles
+	s.traceTime += o.traceTime
+	s.developTime += o.developTime
+	s.denoiseTime += o.denoiseTime
+	s.passes = o.passes
+	s.samplesPerPixel = o.samplesPerPixel
+	s.noise = o.noise
+}
+
+// Average number of rays traced per path leaving the camera.
+func (s renderStats) averagePathLength() float64 {
+	if s.primaryRays == 0 {
+		return 0
+	}
+	return float64(s.primaryRays+s.secondaryRays) / float64(s.primaryRays)
+}
+
+func (s renderStats) encode(w io.Writer) error {
+	var noise *float64
+	if !math.IsInf(s.noise, 0) {
+		noise = &s.noise
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		PrimaryRays       int64    `json:"primaryRays"`
+		SecondaryRays     int64    `json:"secondaryRays"`
+		IntersectionTests int64    `json:"intersectionTests"`
+		AveragePathLength float64  `json:"averagePathLength"`
+		Tiles             int      `json:"tiles"`
+		TraceSeconds      float64  `json:"traceSeconds"`
+		DevelopSeconds    float64  `json:"developSeconds"`
+		DenoiseSeconds    float64  `json:"denoiseSeconds"`
+		RaysPerSecond     float64  `json:"raysPerSecond"`
+		Passes            int      `json:"passes"`
+		SamplesPerPixel   int      `json:"samplesPerPixel"`
+		NoiseEstimate     *float64 `json:"noiseEstimate,omitempty"`
+		StoppedBy         string   `json:"stoppedBy,omitempty"`
+	}{
+		PrimaryRays:       s.primaryRays,
+		SecondaryRays:     s.secondaryRays,
+		IntersectionTests: s.intersectionTests,
+		AveragePathLength: s.averagePathLength(),
+		Tiles:             s.tiles,
+		TraceSeconds:      s.traceTime.Seconds(),
+		DevelopSeconds:    s.developTime.Seconds(),
+		DenoiseSeconds:    s.denoiseTime.Seconds(),
+		RaysPerSecond:     float64(s.primaryRays+s.secondaryRays) / max(s.traceTime.Seconds(), 1e-9),
+		Passes:            s.passes,
+		SamplesPerPixel:   s.samplesPerPixel,
+		NoiseEstimate:     noise,
+		StoppedBy:         s.stoppedBy,
+	})
+}
+
+// Writes the stats as JSON next to the image at path, replacing its
+// extension with .stats.json.
+func saveStats(s renderStats, path string) error {
+	statsPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".stats.json"
+	file, err := os.Create(statsPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.encode(file)
+}
+
+// Called with the number of tiles rendered so far out of the total.
+type progressFunc func(done, total int)
+
+// Returns a progress callback drawing a bar on w, meant for a terminal.
+func progressBar(w io.Writer, label string) progressFunc {
+	start := time.Now()
+	return func(done, total int) {
+		const width = 30
+		filled := width * done / total
+		fmt.Fprintf(w, "\r%s [%s%s] %3d%% %.1fs", label, strings.Repeat("#", filled), strings.Repeat("-", width-filled), 100*done/total, time.Since(start).Seconds())
+		if done == total {
+			fmt.Fprintln(w)
+		}
+	}
+}
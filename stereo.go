@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+type stereoLayout int
+
+const (
+	mono       stereoLayout = iota // A single view filling the image
+	sideBySide                     // Left eye view on the left half, right eye on the right half
+	overUnder                      // Left eye view on the top half, right eye on the bottom half
+)
+
+var stereoLayoutNames = []string{"mono", "sbs", "ou"}
+
+// Returns the size in pixels of a single eye's view within the packed image.
+func (c *camera) viewSize() (float64, float64) {
+	switch c.stereo {
+	case sideBySide:
+		return float64(c.imgWidth) / 2, float64(c.imgHeight)
+	case overUnder:
+		return float64(c.imgWidth), float64(c.imgHeight) / 2
+	}
+	return float64(c.imgWidth), float64(c.imgHeight)
+}
+
+// Maps image coordinates to the eye they belong to, -1 for the left, 1 for
+// the right and 0 for mono images, and to normalized coordinates within that
+// eye's view.
+func (c *camera) viewCoordinates(sx, sy float64) (eye, s, t float64) {
+	viewWidth, viewHeight := c.viewSize()
+	switch c.stereo {
+	case sideBySide:
+		eye = -1
+		if sx >= viewWidth {
+			eye, sx = 1, sx-viewWidth
+		}
+	case overUnder:
+		eye = -1
+		if sy >= viewHeight {
+			eye, sy = 1, sy-viewHeight
+		}
+	}
+	return eye, sx / viewWidth, sy / viewHeight
+}
+
+// Returns the pixels covered by the view of the given eye.
+func (c *camera) viewBounds(eye float64) image.Rectangle {
+	viewWidth, viewHeight := c.viewSize()
+	bounds := image.Rect(0, 0, c.imgWidth, c.imgHeight)
+	switch {
+	case c.stereo == sideBySide && eye < 0:
+		bounds.Max.X = int(viewWidth)
+	case c.stereo == sideBySide && eye > 0:
+		bounds.Min.X = int(viewWidth)
+	case c.stereo == overUnder && eye < 0:
+		bounds.Max.Y = int(viewHeight)
+	case c.stereo == overUnder && eye > 0:
+		bounds.Min.Y = int(viewHeight)
+	}
+	return bounds
+}
+
+// Returns the position of an eye, offset sideways from the camera center by
+// half the interpupillary distance.
+func (c *camera) eyePosition(eye float64) vec3 {
+	return c.center.add(c.u.scale(eye * c.interpupillaryDistance / 2))
+}
+
+// Returns the position of an eye for omni-directional stereo, where the eyes
+// rotate around the camera center to stay perpendicular to the horizontal
+// direction being looked at.
+func (c *camera) odsEyePosition(eye, longitude float64) vec3 {
+	right := c.u.scale(math.Cos(longitude)).add(c.w.scale(math.Sin(longitude)))
+	return c.center.add(right.scale(eye * c.interpupillaryDistance / 2))
+}
+
+func (c *camera) convergenceDistance() float64 {
+	if c.convergence > 0 {
+		return c.convergence
+	}
+	return c.focalDistance
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"cmp"
+	"image"
+	"math"
+	"slices"
+)
+
+// Side in pixels of the square tiles renders are split into.
+const tileSize = 16
+
+// Splits the image into square tiles ordered in a spiral from the center
+// outwards, so the middle of the image finishes first.
+func spiralTiles(width, height, size int) []image.Rectangle {
+	nx, ny := (width+size-1)/size, (height+size-1)/size
+	cx, cy := float64(nx-1)/2, float64(ny-1)/2
+
+	type tile struct {
+		bounds image.Rectangle
+		ring   float64 // Square ring around the center the tile lies on, zero for every central tile
+		angle  float64 // Angle around the center, clockwise on screen
+	}
+	tiles := make([]tile, 0, nx*ny)
+	for ty := range ny {
+		for tx := range nx {
+			dx, dy := float64(tx)-cx, float64(ty)-cy
+			bounds := image.Rect(tx*size, ty*size, min(width, (tx+1)*size), min(height, (ty+1)*size))
+			tiles = append(tiles, tile{bounds, math.Floor(max(math.Abs(dx), math.Abs(dy))), math.Atan2(dy, dx)})
+		}
+	}
+	slices.SortFunc(tiles, func(a, b tile) int {
+		return cmp.Or(cmp.Compare(a.ring, b.ring), cmp.Compare(a.angle, b.angle))
+	})
+
+	rects := make([]image.Rectangle, len(tiles))
+	for i, t := range tiles {
+		rects[i] = t.bounds
+	}
+	return rects
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestSpiralTiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantTiles     int
+	}{
+		{"single tile", 10, 7, 16, 1},
+		{"exact grid", 64, 48, 16, 12},
+		{"partial edges", 70, 33, 16, 15},
+		{"tall", 20, 100, 16, 14},
+		{"one pixel tiles", 5, 3, 1, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiles := spiralTiles(tt.width, tt.height, tt.size)
+			if len(tiles) != tt.wantTiles {
+				t.Fatalf("%d tiles, want %d", len(tiles), tt.wantTiles)
+			}
+
+			covered := make([]int, tt.width*tt.height)
+			for _, tile := range tiles {
+				if !tile.In(image.Rect(0, 0, tt.width, tt.height)) || tile.Empty() {
+					t.Fatalf("tile %v is empty or outside the image", tile)
+				}
+				if tile.Dx() > tt.size || tile.Dy() > tt.size {
+					t.Errorf("tile %v is larger than %d pixels", tile, tt.size)
+				}
+				for y := tile.Min.Y; y < tile.Max.Y; y++ {
+					for x := tile.Min.X; x < tile.Max.X; x++ {
+						covered[y*tt.width+x]++
+					}
+				}
+			}
+			if i := slices.IndexFunc(covered, func(n int) bool { return n != 1 }); i >= 0 {
+				t.Fatalf("pixel %d, %d is covered by %d tiles, want 1", i%tt.width, i/tt.width, covered[i])
+			}
+
+			// Distance from the center of the tile grid, on square rings
+			nx, ny := (tt.width+tt.size-1)/tt.size, (tt.height+tt.size-1)/tt.size
+			ring := func(tile image.Rectangle) float64 {
+				dx := abs(float64(tile.Min.X/tt.size) - float64(nx-1)/2)
+				dy := abs(float64(tile.Min.Y/tt.size) - float64(ny-1)/2)
+				return max(dx, dy)
+			}
+			first := ring(tiles[0])
+			for _, tile := range tiles[1:] {
+				if ring(tile) < first-1e-9 {
+					t.Errorf("tile %v is nearer the center than the first tile %v", tile, tiles[0])
+				}
+			}
+		})
+	}
+}
+
+func TestSpiralTilesRings(t *testing.T) {
+	tiles := spiralTiles(5*16, 5*16, 16)
+	ring := func(tile image.Rectangle) float64 {
+		return max(abs(float64(tile.Min.X/16-2)), abs(float64(tile.Min.Y/16-2)))
+	}
+	if tiles[0] != image.Rect(32, 32, 48, 48) {
+		t.Errorf("first tile %v, want the center one", tiles[0])
+	}
+	for i := 1; i < len(tiles); i++ {
+		if ring(tiles[i]) < ring(tiles[i-1]) {
+			t.Errorf("tile %v on ring %g comes after tile %v on ring %g", tiles[i], ring(tiles[i]), tiles[i-1], ring(tiles[i-1]))
+		}
+	}
+}
+
+func TestRenderCancelled(t *testing.T) {
+	w := checkpointTestWorld()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := checkpointTestCamera(t, false, false, 0)
+	if err := c.renderContext(ctx, w); err == nil {
+		t.Error("cancelled render returned no error")
+	}
+	if c.passes != 0 {
+		t.Errorf("cancelled render left %d passes, want 0", c.passes)
+	}
+
+	c.render(w)
+	color, weight := slices.Clone(c.film.color), slices.Clone(c.film.weight)
+	if err := c.refine(ctx, w); err == nil {
+		t.Error("cancelled refinement returned no error")
+	}
+	if c.passes != 1 {
+		t.Errorf("cancelled refinement left %d passes, want 1", c.passes)
+	}
+	if !slices.Equal(c.film.color, color) || !slices.Equal(c.film.weight, weight) {
+		t.Error("cancelled refinement changed the accumulated film")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type interpolation int
+
+const (
+	linear     interpolation = iota // Straight segments between consecutive keyframes
+	catmullRom                      // Smooth curve through every keyframe
+	bezier                          // Smooth curve using the keyframes as control points
+)
+
+var interpolationNames = []string{"linear", "catmullRom", "bezier"}
+
+type keyframe struct {
+	time          float64 // Seconds since the start of the timeline
+	lookFrom      vec3    // Point in space where the camera eye is located
+	lookAt        vec3    // Point in space where the camera is looking
+	verticalFov   float64 // Vertical view angle
+	focalDistance float64 // Distance from camera lookfrom point to plane of perfect focus
+	roll          float64 // Degrees the camera is rolled counterclockwise around its view direction
+}
+
+type timeline struct {
+	keyframes     []keyframe    // Keyframes sorted by time
+	interpolation interpolation // How camera poses are interpolated between keyframes
+}
+
+func (k keyframe) values() [9]float64 {
+	return [9]float64{k.lookFrom.x, k.lookFrom.y, k.lookFrom.z, k.lookAt.x, k.lookAt.y, k.lookAt.z, k.verticalFov, k.focalDistance, k.roll}
+}
+
+func keyframeFromValues(time float64, v [9]float64) keyframe {
+	return keyframe{
+		time:          time,
+		lookFrom:      vec3{v[0], v[1], v[2]},
+		lookAt:        vec3{v[3], v[4], v[5]},
+		verticalFov:   v[6],
+		focalDistance: v[7],
+		roll:          v[8],
+	}
+}
+
+func (tl *timeline) duration() float64 {
+	if len(tl.keyframes) == 0 {
+		return 0
+	}
+	return tl.keyframes[len(tl.keyframes)-1].time - tl.keyframes[0].time
+}
+
+// Returns the interpolated camera pose at the given time, holding the first
+// and last keyframes outside of the timeline's range.
+func (tl *timeline) at(time float64) keyframe {
+	keys := tl.keyframes
+	first, last := keys[0], keys[len(keys)-1]
+	if time <= first.time || len(keys) == 1 {
+		return keyframeFromValues(time, first.values())
+	}
+	if time >= last.time {
+		return keyframeFromValues(time, last.values())
+	}
+
+	if tl.interpolation == bezier {
+		points := make([][9]float64, len(keys))
+		for i, k := range keys {
+			points[i] = k.values()
+		}
+		return keyframeFromValues(time, deCasteljau(points, (time-first.time)/(last.time-first.time)))
+	}
+
+	i := 0
+	for keys[i+1].time < time {
+		i++
+	}
+	t := (time - keys[i].time) / (keys[i+1].time - keys[i].time)
+	p1, p2 := keys[i].values(), keys[i+1].values()
+	if tl.interpolation == linear {
+		return keyframeFromValues(time, lerpValues(p1, p2, t))
+	}
+
+	p0, p3 := p1, p2
+	if i > 0 {
+		p0 = keys[i-1].values()
+	}
+	if i+2 < len(keys) {
+		p3 = keys[i+2].values()
+	}
+	var v [9]float64
+	for j := range v {
+		v[j] = 0.5 * (2*p1[j] +
+			(p2[j]-p0[j])*t +
+			(2*p0[j]-5*p1[j]+4*p2[j]-p3[j])*t*t +
+			(3*p1[j]-p0[j]-3*p2[j]+p3[j])*t*t*t)
+	}
+	return keyframeFromValues(time, v)
+}
+
+func lerpValues(a, b [9]float64, t float64) [9]float64 {
+	var v [9]float64
+	for j := range v {
+		v[j] = a[j] + (b[j]-a[j])*t
+	}
+	return v
+}
+
+func deCasteljau(points [][9]float64, t float64) [9]float64 {
+	points = slices.Clone(points)
+	for n := len(points) - 1; n > 0; n-- {
+		for i := range n {
+			points[i] = lerpValues(points[i], points[i+1], t)
+		}
+	}
+	return points[0]
+}
+
+// Parses a timeline file. Each non-empty line not starting with # is either
+//
+//	interpolation linear|catmullRom|bezier
+//	key time fromX fromY fromZ atX atY atZ verticalFov focalDistance [roll]
+//
+// where roll defaults to 0.
+func parseTimeline(r io.Reader) (*timeline, error) {
+	tl := &timeline{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "interpolation":
+			if len(fields) != 2 || !slices.Contains(interpolationNames, fields[1]) {
+				return nil, fmt.Errorf("line %d: expected interpolation %s", line, strings.Join(interpolationNames, "|"))
+			}
+			tl.interpolation = interpolation(slices.Index(interpolationNames, fields[1]))
+		case "key":
+			if len(fields) != 10 && len(fields) != 11 {
+				return nil, fmt.Errorf("line %d: expected key followed by 9 or 10 numbers", line)
+			}
+			var v [10]float64
+			for i, field := range fields[1:] {
+				f, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				v[i] = f
+			}
+			tl.keyframes = append(tl.keyframes, keyframeFromValues(v[0], [9]float64(v[1:])))
+		default:
+			return nil, fmt.Errorf("line %d: unknown directive %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tl.keyframes) == 0 {
+		return nil, errors.New("timeline has no keyframes")
+	}
+	slices.SortStableFunc(tl.keyframes, func(a, b keyframe) int { return cmp.Compare(a.time, b.time) })
+	return tl, nil
+}
+
+func loadTimeline(path string) (*timeline, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseTimeline(file)
+}
+
+func (tl *timeline) encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "interpolation %s\n", interpolationNames[tl.interpolation])
+	for _, k := range tl.keyframes {
+		fmt.Fprintf(bw, "key %g", k.time)
+		for _, v := range k.values() {
+			fmt.Fprintf(bw, " %g", v)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+func saveTimeline(tl *timeline, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return tl.encode(file)
+}
+
+func frameFileName(fileName string, frame int) string {
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s_%04d%s", strings.TrimSuffix(fileName, ext), frame, ext)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTimeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *timeline
+		wantErr string // Part of the expected error, empty if parsing should succeed
+	}{
+		{
+			name: "sorted by time with optional roll",
+			input: `# flight
+interpolation catmullRom
+key 2 1 2 3 4 5 6 60 1 45
+key 0 0 0 0 0 0 -1 90 2
+`,
+			want: &timeline{
+				interpolation: catmullRom,
+				keyframes: []keyframe{
+					{time: 0, lookAt: vec3{0, 0, -1}, verticalFov: 90, focalDistance: 2},
+					{time: 2, lookFrom: vec3{1, 2, 3}, lookAt: vec3{4, 5, 6}, verticalFov: 60, focalDistance: 1, roll: 45},
+				},
+			},
+		},
+		{name: "no keyframes", input: "interpolation linear\n", wantErr: "no keyframes"},
+		{name: "short key", input: "key 0 0 0 0 0 0 -1 90\n", wantErr: "line 1"},
+		{name: "long key", input: "key 0 0 0 0 0 0 -1 90 1 0 0\n", wantErr: "line 1"},
+		{name: "bad number", input: "\nkey 0 0 0 0 0 0 -1 90 x\n", wantErr: "line 2"},
+		{name: "unknown interpolation", input: "interpolation cubic\n", wantErr: "line 1"},
+		{name: "unknown directive", input: "camera 0\n", wantErr: "unknown directive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl, err := parseTimeline(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tl, tt.want) {
+				t.Errorf("parsed %+v, want %+v", tl, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimelineAt(t *testing.T) {
+	keys := []keyframe{
+		{time: 0, lookFrom: vec3{0, 0, 0}, verticalFov: 40, focalDistance: 1, roll: 0},
+		{time: 1, lookFrom: vec3{2, 0, 0}, verticalFov: 60, focalDistance: 2, roll: 90},
+		{time: 3, lookFrom: vec3{2, 4, 0}, verticalFov: 80, focalDistance: 3, roll: -90},
+	}
+	tests := []struct {
+		name          string
+		interpolation interpolation
+		time          float64
+		want          keyframe
+	}{
+		{"before the start", linear, -1, keyframe{time: -1, verticalFov: 40, focalDistance: 1}},
+		{"after the end", linear, 5, keyframe{time: 5, lookFrom: vec3{2, 4, 0}, verticalFov: 80, focalDistance: 3, roll: -90}},
+		{"linear", linear, 0.5, keyframe{time: 0.5, lookFrom: vec3{1, 0, 0}, verticalFov: 50, focalDistance: 1.5, roll: 45}},
+		{"linear second segment", linear, 2, keyframe{time: 2, lookFrom: vec3{2, 2, 0}, verticalFov: 70, focalDistance: 2.5}},
+		{"catmull-rom through a keyframe", catmullRom, 1, keys[1]},
+		{"bezier start", bezier, 0, keys[0]},
+		// Quadratic curve over three control points at t = 0.5
+		{"bezier middle", bezier, 1.5, keyframe{time: 1.5, lookFrom: vec3{1.5, 1, 0}, verticalFov: 60, focalDistance: 2, roll: 22.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl := &timeline{keyframes: keys, interpolation: tt.interpolation}
+			got := tl.at(tt.time).values()
+			want := tt.want.values()
+			for i := range got {
+				if abs(got[i]-want[i]) > 1e-9 {
+					t.Fatalf("pose at %g is %v, want %v", tt.time, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTimelineEncodeRoundTrip(t *testing.T) {
+	tl := &timeline{
+		interpolation: bezier,
+		keyframes: []keyframe{
+			{time: 0, lookFrom: vec3{0.5, 1, -2}, lookAt: vec3{0, 0, -1}, verticalFov: 60, focalDistance: 1.25},
+			{time: 1.5, lookFrom: vec3{1, 1, 1}, lookAt: vec3{0, 0, 0}, verticalFov: 35, focalDistance: 2, roll: -30},
+		},
+	}
+	var buf bytes.Buffer
+	if err := tl.encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseTimeline(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, tl) {
+		t.Errorf("round trip gave %+v, want %+v", parsed, tl)
+	}
+}
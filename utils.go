@@ -9,6 +9,10 @@ func deg2rad(degrees float64) float64 {
 	return degrees * math.Pi / 180.0
 }
 
+func rad2deg(radians float64) float64 {
+	return radians * 180.0 / math.Pi
+}
+
 func random() float64 {
 	return rand.Float64()
 }
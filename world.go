@@ -1,5 +1,7 @@
 package main
 
+import "slices"
+
 type world struct {
 	objects []hittable
 }
@@ -8,12 +10,27 @@ func (w world) hit(r ray, tInterval interval, hr *hitRecord) bool {
 	var tempHr hitRecord
 	hitAnything := false
 	closest := tInterval.max
-	for _, object := range w.objects {
+	for i, object := range w.objects {
 		if object.hit(r, interval{tInterval.min, closest}, &tempHr) {
 			hitAnything = true
 			closest = tempHr.t
+			tempHr.objectID = i
 			*hr = tempHr
 		}
 	}
 	return hitAnything
 }
+
+func (w world) materialID(m material) int {
+	var materials []material
+	for _, object := range w.objects {
+		mat := object.surface()
+		if !slices.Contains(materials, mat) {
+			materials = append(materials, mat)
+		}
+		if mat == m {
+			return slices.Index(materials, mat)
+		}
+	}
+	return -1
+}